@@ -0,0 +1,138 @@
+package gomemcached
+
+import (
+	"context"
+	"sync"
+)
+
+// AsyncConcurrency is the default max number of asynchronous operations running at a time per client.
+var AsyncConcurrency = 64
+
+// Future is the pending result of an asynchronous operation.
+type Future struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	cas    uint64
+	err    error
+	// raw result of GetAsync, it is decoded into value by Wait
+	flag      uint32
+	data      []byte
+	value     interface{}
+	decode    sync.Once
+	decodeErr error
+}
+
+func newFuture() *Future {
+	f := &Future{done: make(chan struct{})}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	return f
+}
+
+// newFailedFuture returns a finished future of err.
+func newFailedFuture(err error) *Future {
+	f := newFuture()
+	f.err = err
+	close(f.done)
+	return f
+}
+
+// Wait blocks until the operation finishes, ctx is done or future is canceled.
+// Return value is the same as the synchronous operation.
+func (f *Future) Wait(ctx context.Context) (uint64, error) {
+	select {
+	case <-f.done:
+	case <-f.ctx.Done():
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if f.err != nil {
+		return 0, f.err
+	}
+
+	if f.value != nil {
+		f.decode.Do(func() {
+			f.decodeErr = decodeValue(f.flag, f.data, f.value)
+		})
+		if f.decodeErr != nil {
+			return 0, f.decodeErr
+		}
+	}
+
+	return f.cas, nil
+}
+
+// Done is closed when the operation finishes.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Cancel discards the operation, it is never sent if it is still waiting for a slot of concurrency,
+// otherwise its request is interrupted, a SetAsync may have been applied by server already.
+// A GetAsync shared by the coalesced Gets is not interrupted.
+// Wait returns context.Canceled after Cancel.
+func (f *Future) Cancel() {
+	f.cancel()
+}
+
+func (m *MemcachedClient) SetAsyncConcurrency(n int) {
+	if n <= 0 {
+		n = AsyncConcurrency
+	}
+	m.asyncSem = make(chan struct{}, n)
+}
+
+// GetAsync fetches the value of key in background, `value` is decoded by Future.Wait.
+func (m *MemcachedClient) GetAsync(key string, value interface{}) *Future {
+	key, err := m.checkKey(key)
+	if err != nil {
+		return newFailedFuture(err)
+	}
+
+	f := newFuture()
+	f.value = value
+
+	return m.goAsync(f, func() {
+		f.flag, f.data, f.cas, f.err = m.getCachedRaw(key, f.ctx.Done())
+		if f.err == nil {
+			f.err = m.checkRawTags(f.flag, f.data)
+		}
+	})
+}
+
+// SetAsync stores the value of key in background.
+func (m *MemcachedClient) SetAsync(args *KeyArgs) *Future {
+	f := newFuture()
+	storeArgs := *args
+	return m.goAsync(f, func() {
+		f.cas, f.err = m.store(OPCODE_SET, &storeArgs, true, f.ctx.Done())
+	})
+}
+
+func (m *MemcachedClient) goAsync(f *Future, op func()) *Future {
+	sem := m.asyncSem
+	go func() {
+		defer close(f.done)
+
+		select {
+		case sem <- struct{}{}:
+		case <-f.ctx.Done():
+			return
+		}
+		defer func() { <-sem }()
+
+		// canceled while the slot was acquired
+		if f.ctx.Err() != nil {
+			return
+		}
+
+		op()
+	}()
+
+	return f
+}
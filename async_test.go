@@ -0,0 +1,121 @@
+package gomemcached
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAsync(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	_, err := m.SetAsync(&KeyArgs{Key: "TestAsync", Value: "HelloWorld"}).Wait(context.Background())
+	if err != nil {
+		t.Fatalf("SetAsync err: %v", err)
+	}
+
+	var value string
+	cas, err := m.GetAsync("TestAsync", &value).Wait(context.Background())
+	if err != nil || cas == 0 || value != "HelloWorld" {
+		t.Fatalf("GetAsync: %v, %v, %v", value, cas, err)
+	}
+
+	_, err = m.GetAsync("TestAsyncMissing", &value).Wait(context.Background())
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("GetAsync missing key: %v", err)
+	}
+}
+
+func TestAsyncConcurrency(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetAsyncConcurrency(1)
+
+	_, err := m.Set(&KeyArgs{Key: "TestAsyncConcurrency", Value: "HelloWorld"})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	servers[0].SetDelay(time.Millisecond * 100)
+	var v1, v2, v3 string
+	f1 := m.GetAsync("TestAsyncConcurrency", &v1)
+	// f1 holds the only slot until server answers
+	time.Sleep(time.Millisecond * 20)
+	f2 := m.GetAsync("TestAsyncConcurrency", &v2)
+	f3 := m.GetAsync("TestAsyncConcurrency", &v3)
+	f3.Cancel()
+
+	// f2 waits for f1 to release the slot
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := f2.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("f2 should not finish: %v", err)
+	}
+
+	for _, f := range []*Future{f1, f2} {
+		if _, err := f.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait err: %v", err)
+		}
+	}
+	if v1 != "HelloWorld" || v2 != "HelloWorld" {
+		t.Fatalf("values: %v, %v", v1, v2)
+	}
+
+	if _, err := f3.Wait(context.Background()); err != context.Canceled {
+		t.Fatalf("canceled future: %v", err)
+	}
+	<-f3.Done()
+	if n := servers[0].OpCount(OPCODE_GET); n != 2 {
+		t.Errorf("server received %v gets", n)
+	}
+}
+
+func TestAsyncCancelInFlight(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 1).(*MemcachedClient)
+	defer m.Exit()
+
+	servers[0].SetDelay(time.Millisecond * 1500)
+	var value string
+	futures := []*Future{
+		m.GetAsync("TestAsyncCancelInFlight", &value),
+		m.SetAsync(&KeyArgs{Key: "TestAsyncCancelInFlight", Value: "HelloWorld"}),
+	}
+
+	for _, f := range futures {
+		// the request is sent on the only connection
+		time.Sleep(time.Millisecond * 50)
+		f.Cancel()
+		if _, err := f.Wait(context.Background()); err != context.Canceled {
+			t.Fatalf("canceled future: %v", err)
+		}
+
+		select {
+		case <-f.Done():
+		case <-time.After(time.Millisecond * 500):
+			t.Fatalf("canceled request is not interrupted")
+		}
+	}
+	servers[0].SetDelay(0)
+
+	// the connection of canceled request is renewed without taking server as bad
+	if _, err := m.Set(&KeyArgs{Key: "TestAsyncCancelInFlight", Value: "HelloWorld"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+	m.cluster.RLock()
+	bad := len(m.cluster.addr2Servers[servers[0].Addr()].badCmders) > 0
+	m.cluster.RUnlock()
+	if bad {
+		t.Errorf("canceled request takes server as bad")
+	}
+}
@@ -0,0 +1,258 @@
+package gomemcached
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+// Batch queues operations of different kinds and executes them together.
+// Operations are routed to the primary server of their keys(replication is not applied),
+// the operations of a server are pipelined as quiet requests tagged by opaque and keep their order.
+type Batch struct {
+	client *MemcachedClient
+	ops    []batchOp
+	// maps the keys of operations before they are checked, nil for the keys of client
+	mapKey func(key string) (string, error)
+}
+
+type batchOp struct {
+	opCode uint8
+	args   KeyArgs
+	value  interface{}
+}
+
+// BatchResult is the result of an operation in batch.
+type BatchResult struct {
+	Key string
+	// CAS of Get/Increment/Touch.
+	// Set/Add/Delete/Append are sent quietly and only answer failures, so their CAS is always 0.
+	CAS uint64
+	// Counter value of Increment.
+	Counter uint64
+	Err     error
+
+	// tag versions of the tagged value of Get
+	tags map[string]uint64
+}
+
+func (m *MemcachedClient) NewBatch() *Batch {
+	return &Batch{client: m}
+}
+
+// Get decodes the value of key into `value` when the batch is executed.
+func (b *Batch) Get(key string, value interface{}) *Batch {
+	return b.add(OPCODE_GETQ, &KeyArgs{Key: key}, value)
+}
+
+func (b *Batch) Set(args *KeyArgs) *Batch {
+	return b.add(OPCODE_SETQ, args, nil)
+}
+
+func (b *Batch) Add(args *KeyArgs) *Batch {
+	return b.add(OPCODE_ADDQ, args, nil)
+}
+
+func (b *Batch) Delete(args *KeyArgs) *Batch {
+	return b.add(OPCODE_DELQ, args, nil)
+}
+
+func (b *Batch) Increment(args *KeyArgs) *Batch {
+	return b.add(OPCODE_INCR, args, nil)
+}
+
+func (b *Batch) Touch(args *KeyArgs) *Batch {
+	return b.add(OPCODE_TOUCH, args, nil)
+}
+
+func (b *Batch) Append(args *KeyArgs) *Batch {
+	return b.add(OPCODE_APPENDQ, args, nil)
+}
+
+func (b *Batch) add(opCode uint8, args *KeyArgs, value interface{}) *Batch {
+	op := batchOp{opCode: opCode, args: *args, value: value}
+	op.args.useMsgpack = opCode == OPCODE_SETQ || opCode == OPCODE_ADDQ
+	b.ops = append(b.ops, op)
+	return b
+}
+
+// Execute sends every queued operation, the batches of different servers are sent concurrently.
+// Return value is the results in the order operations were queued,
+// a failed operation does not abort the others.
+func (b *Batch) Execute() []BatchResult {
+	results := make([]BatchResult, len(b.ops))
+	args := make([]*KeyArgs, len(b.ops))
+	keys := make([]string, len(b.ops))
+	for i := range b.ops {
+		results[i].Key = b.ops[i].args.Key
+
+		checked, err := b.checkArgs(&b.ops[i].args)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		args[i] = checked
+		keys[i] = checked.Key
+		if b.ops[i].opCode != OPCODE_GETQ {
+			defer b.client.invalidateNearCache(checked.Key)
+		}
+	}
+
+	groups, counts := b.client.cluster.groupKeysByServer(keys, 1)
+	for i := range results {
+		if counts[i] <= 0 && results[i].Err == nil {
+			results[i].Err = ErrNotFoundServerNode
+		}
+	}
+
+	var wg sync.WaitGroup
+	for s, indexes := range groups {
+		wg.Add(1)
+		go func(s *Server, indexes []int) {
+			defer wg.Done()
+			for start := 0; start < len(indexes); start += MultiBatchSize {
+				end := start + MultiBatchSize
+				if end > len(indexes) {
+					end = len(indexes)
+				}
+				b.execute(s, indexes[start:end], args, results)
+			}
+		}(s, indexes)
+	}
+	wg.Wait()
+
+	b.checkTags(results)
+	return results
+}
+
+// checkTags treats the tagged values of Get whose tags moved on as missing,
+// the tags of all results are checked together.
+func (b *Batch) checkTags(results []BatchResult) {
+	var names []string
+	seen := make(map[string]bool)
+	for i := range results {
+		for tag := range results[i].tags {
+			if !seen[tag] {
+				seen[tag] = true
+				names = append(names, tag)
+			}
+		}
+	}
+
+	if len(names) <= 0 {
+		return
+	}
+
+	versions, err := b.client.tagVersions(names, 0)
+	for i := range results {
+		if results[i].tags == nil || results[i].Err != nil {
+			continue
+		}
+
+		if err != nil {
+			results[i].Err = err
+		} else if staleTags(results[i].tags, versions) {
+			results[i].Err = ErrKeyNotFound
+		}
+	}
+}
+
+func (b *Batch) checkArgs(args *KeyArgs) (*KeyArgs, error) {
+	if b.mapKey != nil {
+		mapped := *args
+		var err error
+		if mapped.Key, err = b.mapKey(args.Key); err != nil {
+			return nil, err
+		}
+		args = &mapped
+	}
+
+	return b.client.checkArgs(args)
+}
+
+func (b *Batch) execute(s *Server, indexes []int, args []*KeyArgs, results []BatchResult) {
+	req := bytebufferpool.Get()
+	defer bytebufferpool.Put(req)
+
+	sent := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		if err := b.ops[i].writeReq(req, args[i], uint32(len(sent))); err != nil {
+			results[i].Err = err
+			continue
+		}
+		sent = append(sent, i)
+	}
+
+	if len(sent) <= 0 {
+		return
+	}
+
+	answered := make([]bool, len(sent))
+	err := b.client.sendQuietBatch(s, req, uint32(len(sent)), func(rsp *response) {
+		answered[rsp.opaque] = true
+		i := sent[rsp.opaque]
+		b.ops[i].parseRsp(rsp, &results[i])
+	})
+
+	for n, i := range sent {
+		if answered[n] {
+			continue
+		}
+
+		switch {
+		case err != nil:
+			results[i].Err = err
+		case b.ops[i].opCode == OPCODE_GETQ:
+			// quiet Get does not answer miss
+			results[i].Err = ErrKeyNotFound
+		case b.ops[i].opCode == OPCODE_INCR || b.ops[i].opCode == OPCODE_TOUCH:
+			results[i].Err = fmt.Errorf("%w: %v is not answered", ErrProtocol, opName(b.ops[i].opCode))
+		}
+	}
+
+	for _, i := range sent {
+		results[i].Err = newOpError(b.ops[i].opCode, args[i].Key, s.Addr, results[i].Err)
+	}
+}
+
+// writeReq appends the request of operation to req, `args` is the checked copy of operation arguments.
+func (op *batchOp) writeReq(req *bytebufferpool.ByteBuffer, args *KeyArgs, opaque uint32) error {
+	switch op.opCode {
+	case OPCODE_GETQ:
+		writeGetReq(req, op.opCode, args.Key, opaque)
+	case OPCODE_SETQ, OPCODE_ADDQ:
+		return writeStoreReq(req, op.opCode, args, opaque)
+	case OPCODE_DELQ:
+		writeDeleteReq(req, op.opCode, args.Key, args.CAS, opaque)
+	case OPCODE_INCR:
+		writeAtomicReq(req, op.opCode, args, opaque)
+	case OPCODE_TOUCH:
+		writeTouchReq(req, args, opaque)
+	case OPCODE_APPENDQ:
+		return writeAppendReq(req, op.opCode, args, opaque)
+	}
+
+	return nil
+}
+
+func (op *batchOp) parseRsp(rsp *response, res *BatchResult) {
+	if res.Err = rsp.statusErr(); res.Err != nil {
+		return
+	}
+
+	body := rsp.body.Bytes()
+	switch op.opCode {
+	case OPCODE_GETQ:
+		flag := binary.BigEndian.Uint32(body[:rsp.extLen])
+		if res.tags, res.Err = decodeValueTags(flag, body[rsp.extLen:], op.value); res.Err != nil {
+			return
+		}
+	case OPCODE_INCR:
+		res.Counter = binary.BigEndian.Uint64(body[rsp.extLen:])
+	}
+
+	res.CAS = rsp.cas
+}
@@ -0,0 +1,61 @@
+package gomemcached
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	_, err := m.SetRawData(&KeyArgs{Key: "TestBatchRaw", Value: []byte("Hello")})
+	if err != nil {
+		t.Fatalf("SetRawData err: %v", err)
+	}
+
+	var value, missing string
+	results := m.NewBatch().
+		Set(&KeyArgs{Key: "TestBatch", Value: "HelloWorld"}).
+		Increment(&KeyArgs{Key: "TestBatchCounter", Delta: 1, Initial: 5}).
+		Increment(&KeyArgs{Key: "TestBatchCounter", Delta: 1}).
+		Add(&KeyArgs{Key: "TestBatch", Value: "HelloAgain"}).
+		Get("TestBatch", &value).
+		Get("TestBatchMissing", &missing).
+		Touch(&KeyArgs{Key: "TestBatch", Expiration: 100}).
+		Append(&KeyArgs{Key: "TestBatchRaw", Value: []byte("World")}).
+		Append(&KeyArgs{Key: "TestBatchMissing", Value: []byte("World")}).
+		Delete(&KeyArgs{Key: "TestBatchCounter"}).
+		Execute()
+
+	wantErrs := []error{nil, nil, nil, ErrKeyExists, nil, ErrKeyNotFound, nil, nil, ErrItemNotStored, nil}
+	if len(results) != len(wantErrs) {
+		t.Fatalf("%v results", len(results))
+	}
+	for i, res := range results {
+		if !errors.Is(res.Err, wantErrs[i]) {
+			t.Errorf("result %v of %v: %v, want %v", i, res.Key, res.Err, wantErrs[i])
+		}
+	}
+
+	if results[1].Counter != 5 || results[2].Counter != 6 {
+		t.Errorf("counters: %v, %v", results[1].Counter, results[2].Counter)
+	}
+	if value != "HelloWorld" || results[4].CAS == 0 {
+		t.Errorf("Get: %v, %v", value, results[4].CAS)
+	}
+	if results[6].CAS == 0 {
+		t.Errorf("Touch returns no CAS")
+	}
+
+	var raw []byte
+	if _, err := m.Get("TestBatchRaw", &raw); err != nil || string(raw) != "HelloWorld" {
+		t.Errorf("Get: %s, %v", raw, err)
+	}
+	if _, err := m.TouchAtomicValue("TestBatchCounter"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("counter is not deleted: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package gomemcached
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configures the circuit breaker of every server,
+// zero fields are replaced by the default values.
+type BreakerConfig struct {
+	// The counts of closed state are reset every Interval, default 10s.
+	Interval time.Duration
+	// Breaker opens when at least MinRequests are made in Interval
+	// and the error rate reaches ErrorRate, default 20 and 0.5.
+	MinRequests uint32
+	ErrorRate   float64
+	// Breaker opens when ConsecutiveTimeouts requests time out in a row, default 5.
+	ConsecutiveTimeouts uint32
+	// How long breaker stays open before it becomes half-open, default 5s.
+	OpenTimeout time.Duration
+	// Requests allowed in half-open state, breaker closes when all of them succeed, default 1.
+	HalfOpenRequests uint32
+	// Reroute the requests of an open server to the next server on ring,
+	// instead of failing them with ErrCircuitOpen.
+	Reroute bool
+}
+
+func (cfg BreakerConfig) withDefaults() *BreakerConfig {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Duration(10) * time.Second
+	}
+	if cfg.MinRequests <= 0 {
+		cfg.MinRequests = 20
+	}
+	if cfg.ErrorRate <= 0 {
+		cfg.ErrorRate = 0.5
+	}
+	if cfg.ConsecutiveTimeouts <= 0 {
+		cfg.ConsecutiveTimeouts = 5
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = time.Duration(5) * time.Second
+	}
+	if cfg.HalfOpenRequests <= 0 {
+		cfg.HalfOpenRequests = 1
+	}
+	return &cfg
+}
+
+type circuitBreaker struct {
+	cfg                 *BreakerConfig
+	addr                string
+	cluster             *Cluster
+	state               BreakerState
+	generation          uint64
+	expiry              time.Time
+	requests            uint32
+	failures            uint32
+	consecutiveTimeouts uint32
+	halfOpenRequests    uint32
+	halfOpenSuccesses   uint32
+	sync.Mutex
+}
+
+func newCircuitBreaker(cfg *BreakerConfig, addr string, cl *Cluster) *circuitBreaker {
+	cb := &circuitBreaker{
+		cfg:     cfg,
+		addr:    addr,
+		cluster: cl,
+	}
+	cb.toState(BreakerClosed, time.Now())
+	return cb
+}
+
+// allow returns the generation of request which is passed to `done`,
+// or ErrCircuitOpen when the request is rejected.
+func (cb *circuitBreaker) allow() (uint64, error) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	now := time.Now()
+	cb.refresh(now)
+
+	switch cb.state {
+	case BreakerOpen:
+		return 0, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if cb.halfOpenRequests >= cb.cfg.HalfOpenRequests {
+			return 0, ErrCircuitOpen
+		}
+		cb.halfOpenRequests++
+	default:
+		cb.requests++
+	}
+
+	return cb.generation, nil
+}
+
+func (cb *circuitBreaker) done(generation uint64, err error) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	now := time.Now()
+	cb.refresh(now)
+
+	// the request was allowed in a previous state
+	if generation != cb.generation {
+		return
+	}
+
+	failed, timeout := breakerFailure(err)
+	switch cb.state {
+	case BreakerHalfOpen:
+		if failed {
+			cb.toState(BreakerOpen, now)
+			return
+		}
+
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= cb.cfg.HalfOpenRequests {
+			cb.toState(BreakerClosed, now)
+		}
+	case BreakerClosed:
+		if !failed {
+			cb.consecutiveTimeouts = 0
+			return
+		}
+
+		cb.failures++
+		if timeout {
+			cb.consecutiveTimeouts++
+		} else {
+			cb.consecutiveTimeouts = 0
+		}
+
+		if cb.consecutiveTimeouts >= cb.cfg.ConsecutiveTimeouts ||
+			(cb.requests >= cb.cfg.MinRequests && float64(cb.failures)/float64(cb.requests) >= cb.cfg.ErrorRate) {
+			cb.toState(BreakerOpen, now)
+		}
+	}
+}
+
+// release gives back a request which was allowed but never sent.
+func (cb *circuitBreaker) release(generation uint64) {
+	cb.Lock()
+	defer cb.Unlock()
+
+	if generation != cb.generation {
+		return
+	}
+
+	if cb.state == BreakerHalfOpen && cb.halfOpenRequests > 0 {
+		cb.halfOpenRequests--
+	} else if cb.state == BreakerClosed && cb.requests > 0 {
+		cb.requests--
+	}
+}
+
+func (cb *circuitBreaker) refresh(now time.Time) {
+	if now.Before(cb.expiry) {
+		return
+	}
+
+	switch cb.state {
+	case BreakerClosed:
+		cb.toState(BreakerClosed, now)
+	case BreakerOpen:
+		cb.toState(BreakerHalfOpen, now)
+	}
+}
+
+func (cb *circuitBreaker) toState(state BreakerState, now time.Time) {
+	prev := cb.state
+	cb.state = state
+	cb.generation++
+	cb.requests, cb.failures, cb.consecutiveTimeouts = 0, 0, 0
+	cb.halfOpenRequests, cb.halfOpenSuccesses = 0, 0
+
+	switch state {
+	case BreakerClosed:
+		cb.expiry = now.Add(cb.cfg.Interval)
+	case BreakerOpen:
+		cb.expiry = now.Add(cb.cfg.OpenTimeout)
+	default:
+		// half-open lasts until the probe requests finish
+		cb.expiry = time.Time{}.Add(1<<63 - 1)
+	}
+
+	if prev != state && cb.cluster != nil && cb.cluster.breakerCallback != nil {
+		// callback may call client, never run it under locks
+		go cb.cluster.breakerCallback(cb.addr, prev, state)
+	}
+}
+
+// breakerFailure classifies the result of a request.
+// The server answered when the error is a memcached status, except the status of overloaded server.
+func breakerFailure(err error) (bool, bool) {
+	var callerErr *callerError
+	if err == nil || errors.Is(err, ErrNoUsableConnection) || errors.As(err, &callerErr) {
+		return false, false
+	}
+
+	if errorClass(err) == ErrTimeout {
+		return true, true
+	}
+
+	if isStatusError(err) {
+		if errors.Is(err, ErrBusy) || errors.Is(err, ErrTemporaryFailure) || errors.Is(err, ErrOutOfMemory) {
+			return true, false
+		}
+		return false, false
+	}
+
+	return true, false
+}
+
+func (s *Server) allow() (uint64, error) {
+	if s.breaker == nil {
+		return 0, nil
+	}
+	return s.breaker.allow()
+}
+
+func (s *Server) done(generation uint64, err error) {
+	if s.breaker != nil {
+		s.breaker.done(generation, err)
+	}
+}
+
+func (s *Server) release(generation uint64) {
+	if s.breaker != nil {
+		s.breaker.release(generation)
+	}
+}
+
+func (cl *Cluster) setCircuitBreaker(cfg *BreakerConfig) {
+	cl.Lock()
+	defer cl.Unlock()
+
+	if cfg != nil {
+		cfg = cfg.withDefaults()
+	}
+	cl.breakerConfig = cfg
+
+	for _, s := range cl.addr2Servers {
+		s.breaker = cl.newServerBreaker(s)
+	}
+}
+
+func (cl *Cluster) newServerBreaker(s *Server) *circuitBreaker {
+	if cl.breakerConfig == nil {
+		return nil
+	}
+	return newCircuitBreaker(cl.breakerConfig, s.Addr, cl)
+}
+
+func (m *MemcachedClient) SetCircuitBreaker(cfg *BreakerConfig) {
+	m.cluster.setCircuitBreaker(cfg)
+}
+
+func (m *MemcachedClient) SetBreakerStateCallback(call BreakerStateCallback) {
+	m.cluster.breakerCallback = call
+}
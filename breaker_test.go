@@ -0,0 +1,106 @@
+package gomemcached
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	cfg := BreakerConfig{MinRequests: 4, ErrorRate: 0.5, OpenTimeout: time.Millisecond * 50}
+	cb := newCircuitBreaker(cfg.withDefaults(), "127.0.0.1:11211", nil)
+
+	request := func(err error) error {
+		generation, allowErr := cb.allow()
+		if allowErr != nil {
+			return allowErr
+		}
+		cb.done(generation, err)
+		return nil
+	}
+
+	request(nil)
+	request(ErrKeyNotFound)
+	request(errors.New("broken pipe"))
+	if cb.state != BreakerClosed {
+		t.Fatalf("breaker opens before MinRequests")
+	}
+
+	request(errors.New("broken pipe"))
+	if cb.state != BreakerOpen {
+		t.Fatalf("breaker state: %v", cb.state)
+	}
+
+	if err := request(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open breaker allows request: %v", err)
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	generation, err := cb.allow()
+	if err != nil || cb.state != BreakerHalfOpen {
+		t.Fatalf("breaker does not become half-open: %v, %v", cb.state, err)
+	}
+
+	if _, err := cb.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("half-open breaker allows too many requests")
+	}
+
+	cb.done(generation, nil)
+	if cb.state != BreakerClosed {
+		t.Fatalf("breaker state: %v", cb.state)
+	}
+}
+
+func TestCircuitBreakerTimeout(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	readTimeout := ReadTimeout
+	ReadTimeout = time.Millisecond * 50
+	defer func() { ReadTimeout = readTimeout }()
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 5).(*MemcachedClient)
+	defer m.Exit()
+
+	changes := make(chan BreakerState, 10)
+	m.SetBreakerStateCallback(func(addr string, from, to BreakerState) {
+		changes <- to
+	})
+	m.SetCircuitBreaker(&BreakerConfig{ConsecutiveTimeouts: 2})
+
+	key := "TestCircuitBreakerTimeout"
+	primary := fakeServerByAddr(servers, m.cluster.ChooseServersByKey(key, 1)[0].Addr)
+	primary.SetDelay(time.Millisecond * 200)
+
+	for i := 0; i < 2; i++ {
+		var value string
+		if _, err := m.Get(key, &value); err == nil {
+			t.Fatalf("Get should time out")
+		}
+	}
+
+	start := time.Now()
+	var value string
+	if _, err := m.Get(key, &value); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get err: %v", err)
+	}
+	if time.Since(start) > ReadTimeout {
+		t.Fatalf("open breaker does not fail fast")
+	}
+
+	select {
+	case state := <-changes:
+		if state != BreakerOpen {
+			t.Fatalf("breaker changes to %v", state)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("breaker callback is not called")
+	}
+
+	// reroute to the other server
+	m.SetCircuitBreaker(&BreakerConfig{ConsecutiveTimeouts: 1, Reroute: true})
+	m.Get(key, &value)
+	if _, err := m.Get(key, &value); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("rerouted Get err: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package gomemcached
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// Expiration in seconds of the lock which protects the loader.
+	LoadLockExpiration uint32 = 10
+	// How long GetOrLoad waits for another process to load value.
+	LoadWaitTimeout = time.Duration(5) * time.Second
+	// How often the waiting GetOrLoad checks whether value has been loaded.
+	LoadPollInterval = time.Duration(50) * time.Millisecond
+	// A stale copy of loaded value outlives the value by StaleExpiration seconds,
+	// GetOrLoad returns it instead of waiting when another process is loading value.
+	// 0 disables the stale copy.
+	StaleExpiration uint32 = 0
+)
+
+const (
+	loadLockSuffix = "#lock"
+	staleSuffix    = "#stale"
+)
+
+func (m *MemcachedClient) GetOrLoad(key string, dst interface{}, ttl uint32, loader func() (interface{}, error)) (uint64, error) {
+	lockKey := key + loadLockSuffix
+	staleKey := key + staleSuffix
+	triedStale := false
+
+	deadline := time.Now().Add(LoadWaitTimeout)
+	for {
+		cas, err := m.Get(key, dst)
+		if !errors.Is(err, ErrKeyNotFound) {
+			return cas, err
+		}
+
+		lockCAS, err := m.AddRawData(&KeyArgs{Key: lockKey, Value: []byte("1"), Expiration: LoadLockExpiration})
+		if err == nil {
+			return m.load(key, lockKey, lockCAS, staleKey, dst, ttl, loader)
+		}
+
+		if !errors.Is(err, ErrKeyExists) && !errors.Is(err, ErrItemNotStored) {
+			return 0, err
+		}
+
+		// another process is loading value
+		if StaleExpiration > 0 && !triedStale {
+			triedStale = true
+			if _, err := m.Get(staleKey, dst); err == nil {
+				return 0, nil
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			return 0, ErrLoadTimeout
+		}
+		time.Sleep(LoadPollInterval)
+	}
+}
+
+func (m *MemcachedClient) load(key, lockKey string, lockCAS uint64, staleKey string, dst interface{}, ttl uint32,
+	loader func() (interface{}, error)) (uint64, error) {
+	// the lock may have expired and been taken by another process while loader runs
+	defer m.Delete(&KeyArgs{Key: lockKey, CAS: lockCAS})
+
+	// value may be loaded by another process between Get and acquiring lock
+	cas, err := m.Get(key, dst)
+	if !errors.Is(err, ErrKeyNotFound) {
+		return cas, err
+	}
+
+	value, err := loader()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := assignValue(value, dst); err != nil {
+		return 0, err
+	}
+
+	// the loaded value is returned even if it can not be stored
+	cas, err = m.Set(&KeyArgs{Key: key, Value: value, Expiration: ttl})
+	if err != nil {
+		return 0, nil
+	}
+
+	if StaleExpiration > 0 {
+		staleExpiration := uint32(0)
+		if ttl > MaxRelativeExpiration {
+			staleExpiration = ttl + StaleExpiration
+		} else if ttl > 0 {
+			staleExpiration = ttlExpiration(time.Duration(ttl)*time.Second+time.Duration(StaleExpiration)*time.Second, time.Now())
+		}
+		m.Set(&KeyArgs{Key: staleKey, Value: value, Expiration: staleExpiration})
+	}
+
+	return cas, nil
+}
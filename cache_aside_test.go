@@ -0,0 +1,127 @@
+package gomemcached
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 10).(*MemcachedClient)
+	defer m.Exit()
+
+	var loads int32
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(time.Millisecond * 100)
+		return &Person{Name: "lennon", Age: 29}, nil
+	}
+
+	persons := make([]Person, 8)
+	errs := make([]error, len(persons))
+	var wg sync.WaitGroup
+	for i := range persons {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = m.GetOrLoad("TestGetOrLoad", &persons[i], 0, loader)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range persons {
+		if errs[i] != nil || persons[i].Name != "lennon" {
+			t.Fatalf("GetOrLoad %v: %v, %v", i, persons[i], errs[i])
+		}
+	}
+
+	if loads != 1 {
+		t.Errorf("loader runs %v times", loads)
+	}
+
+	if _, _, ok := servers[0].Value("TestGetOrLoad" + loadLockSuffix); ok {
+		t.Errorf("lock is not released")
+	}
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	errLoad := errors.New("load failed")
+	var p Person
+	_, err := m.GetOrLoad("TestGetOrLoadError", &p, 0, func() (interface{}, error) {
+		return nil, errLoad
+	})
+	if err != errLoad {
+		t.Fatalf("GetOrLoad err: %v", err)
+	}
+
+	// the next caller can load value since lock was released
+	_, err = m.GetOrLoad("TestGetOrLoadError", &p, 0, func() (interface{}, error) {
+		return &Person{Name: "yoko"}, nil
+	})
+	if err != nil || p.Name != "yoko" {
+		t.Fatalf("GetOrLoad: %v, %v", p, err)
+	}
+}
+
+func TestGetOrLoadLockTakenOver(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	lockKey := "TestGetOrLoadLock" + loadLockSuffix
+	var p Person
+	_, err := m.GetOrLoad("TestGetOrLoadLock", &p, 0, func() (interface{}, error) {
+		// the lock expired and another process took it while loading
+		if _, err := m.SetRawData(&KeyArgs{Key: lockKey, Value: []byte("2")}); err != nil {
+			return nil, err
+		}
+		return &Person{Name: "lennon"}, nil
+	})
+	if err != nil || p.Name != "lennon" {
+		t.Fatalf("GetOrLoad: %v, %v", p, err)
+	}
+
+	if _, _, ok := servers[0].Value(lockKey); !ok {
+		t.Errorf("lock of another process is deleted")
+	}
+}
+
+func TestGetOrLoadStaleExpiration(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	staleExpiration := StaleExpiration
+	StaleExpiration = 60
+	defer func() { StaleExpiration = staleExpiration }()
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	var p Person
+	_, err := m.GetOrLoad("TestGetOrLoadStale", &p, MaxRelativeExpiration, func() (interface{}, error) {
+		return &Person{Name: "lennon"}, nil
+	})
+	if err != nil {
+		t.Fatalf("GetOrLoad err: %v", err)
+	}
+
+	// ttl plus StaleExpiration is longer than MaxRelativeExpiration, it must not be taken as a Unix time
+	expires, ok := servers[0].Expiration("TestGetOrLoadStale" + staleSuffix)
+	want := time.Now().Add(time.Second * (MaxRelativeExpiration + 60))
+	if !ok || expires.Sub(want) > time.Second*2 || want.Sub(expires) > time.Second*2 {
+		t.Errorf("stale copy expires at %v, want %v", expires, want)
+	}
+}
@@ -1,15 +1,31 @@
 package gomemcached
 
+import (
+	"context"
+	"io"
+	"time"
+)
+
 type ServerErrorCallback func(addr string)
 
+type BreakerStateCallback func(addr string, from, to BreakerState)
+
 type KeyArgs struct {
-	Key        string
-	Value      interface{}
+	Key   string
+	Value interface{}
+	// Expiration in the encoding of memcached,
+	// the seconds up to MaxRelativeExpiration are relative to now and the larger ones are Unix time.
 	Expiration uint32
-	CAS        uint64
-	Delta      uint64
+	// TTL and ExpiresAt are converted to Expiration, only one of the three can be set.
+	TTL       time.Duration
+	ExpiresAt time.Time
+	CAS       uint64
+	Delta     uint64
+	Initial   uint64
 
 	useMsgpack bool
+	// flag stored with value, USE_MSGP_FLAG if it is 0 and value is encoded by msgpack
+	flag uint32
 }
 
 type Client interface {
@@ -20,21 +36,108 @@ type Client interface {
 	// The callback's parameter is server address.
 	SetServerErrorCallback(errCall ServerErrorCallback)
 
-	// Exit client by manual control.
-	// In theory that client will not be available after this function is called.
+	// Enable a circuit breaker for every server, nil disables it.
+	// When the breaker of a server is open, its requests fail with ErrCircuitOpen
+	// or are rerouted to the next server on ring if `cfg.Reroute` is true.
+	SetCircuitBreaker(cfg *BreakerConfig)
+
+	// Set callback when the breaker state of a server changes.
+	// The callback is called in a new goroutine.
+	SetBreakerStateCallback(call BreakerStateCallback)
+
+	// Retry failed operations on fresh connections, nil disables retry.
+	// Append/Prepend/Increment/Decrement and the writes with CAS are never retried unless `policy.RetryNonIdempotent` is true.
+	SetRetryPolicy(policy *RetryPolicy)
+
+	// Share `connsPerServer` pipelined connections of every server among all operations, 0 disables pipelining.
+	// Requests are multiplexed on a connection and their responses are matched by the opaque field,
+	// so many operations can be in flight on one connection.
+	SetPipelining(connsPerServer int)
+
+	// Replace the keys longer than MaxKeyLength with their prefix and SHA-256 digest,
+	// instead of rejecting them with ErrInvalidKey.
+	SetLongKeyHashing(enable bool)
+
+	// Extend every TTL by a random duration up to `jitter` times of it, so the keys stored together do not expire together.
+	// 0 disables jitter, Expiration and ExpiresAt are never jittered.
+	SetTTLJitter(jitter float64)
+
+	// Discover servers by resolver.
+	// Resolver is polled every `interval`(ResolveInterval if it is 0) or subscribed when it is a `ServerWatcher`,
+	// new servers are added to cluster and missing servers are removed from cluster.
+	SetServerResolver(resolver ServerResolver, interval time.Duration)
+
+	// Store every key on `factor` distinct servers of the ring, 1 disables replication.
+	// Set/Add/Replace/Delete/Touch are written to all of them and succeed when `ack` is satisfied,
+	// `policy` decides what to do with the copies when some servers failed the write.
+	// Get tries primary server first then falls back to the replicas on miss or error.
+	SetReplication(factor int, ack WriteAck, policy PartialWritePolicy)
+
+	// Enable a local LRU cache of raw values in front of memcached,
+	// it is bounded by `maxEntries` and `maxBytes`(0 means unlimited), every entry lives `ttl` at most.
+	// An entry is invalidated when the key is modified through this client.
+	// Zero `ttl` or no bounds disables the cache.
+	SetNearCache(maxEntries int, maxBytes int64, ttl time.Duration)
+
+	// Hit/miss statistics of the near cache.
+	NearCacheStats() NearCacheStats
+
+	// Coalesce concurrent Gets of the same key into one request,
+	// every caller still decodes the shared result into its own `value`.
+	SetGetCoalescing(enable bool)
+
+	// Send a Get also to the next server on ring(the replica when replication is enabled)
+	// when the primary server has not answered after the delay of `policy`, the first successful answer wins.
+	// nil disables hedging.
+	SetHedging(policy *HedgingPolicy)
+
+	// Choose where the keys of a dead server go: FailureRehash moves them to the next servers on ring,
+	// FailureFailFast fails them with ErrServerUnavailable, FailureReadOnly also reads them from the next server.
+	// A dead server is kept on ring for `grace` before it is removed under FailureRehash.
+	SetFailurePolicy(policy FailurePolicy, grace time.Duration)
+
+	// Exit client by manual control, same as `Close` without deadline.
+	// The client is not available after this function is called.
 	Exit()
 
+	// Close client gracefully, new operations fail with ErrClientClosed,
+	// the in-flight operations are waited until ctx is done,
+	// then every connection is closed after QUIT and the background goroutines stop.
+	Close(ctx context.Context) error
+
 	// Get the value of key.
 	// `value` is a pointer to a value variable.
 	// Return value is the CAS corresponding to the key,
 	// the error is nil when the operation is successful.
 	Get(key string, value interface{}) (uint64, error)
 
+	// Get the value of key, load it by `loader` and store it with `ttl` when the key is not found.
+	// Only one process runs loader at a time, the others wait until value is stored,
+	// or return the stale copy when `StaleExpiration` is enabled(CAS is 0 in this case).
+	// `dst` is a pointer to a value variable.
+	GetOrLoad(key string, dst interface{}, ttl uint32, loader func() (interface{}, error)) (uint64, error)
+
+	// Get the value of key in background, `value` is decoded when the returned Future is waited.
+	GetAsync(key string, value interface{}) *Future
+
 	// Set the value of key.
 	// Return value is the CAS corresponding to the key,
 	// the error is nil when operation is successful.
 	Set(args *KeyArgs) (uint64, error)
 
+	// Set the value of key in background.
+	SetAsync(args *KeyArgs) *Future
+
+	// Limit the number of asynchronous operations running at a time, AsyncConcurrency by default.
+	// The other operations wait for a slot, they can be canceled before they are sent.
+	SetAsyncConcurrency(n int)
+
+	// Read-modify-write the value of key with CAS, retry with jittered backoff on conflict.
+	// `fn` receives the current value decoded into `dst`(nil when the key does not exist, then `Add` is used),
+	// and returns the next value and expiration, or ErrUpdateAbort/ErrUpdateDelete.
+	// Return value is the number of attempts, `dst` holds the stored value when the error is nil.
+	Update(key string, dst interface{}, fn UpdateFunc) (int, error)
+
 	// Same as `Set`.
 	// When increase or decrease part of the data, must use this function.
 	// Return value is CAS,
@@ -57,6 +160,26 @@ type Client interface {
 	// When increase or decrease part of the data, must use this function.
 	ReplaceRawData(args *KeyArgs) (uint64, error)
 
+	// Delete the key.
+	// If CAS is nonzero, the key is deleted only if its CAS is identical to the provided value.
+	// The error is nil when the operation is successful.
+	Delete(args *KeyArgs) error
+
+	// Set the values of many keys in batches of quiet requests, the batches are sent to servers concurrently.
+	// Return value is the errors of failed keys, it is empty when every key is stored.
+	SetMulti(items []*KeyArgs) map[string]error
+
+	// Delete many keys in batches of quiet requests, the batches are sent to servers concurrently.
+	// Return value is the errors of failed keys, a missing key fails with ErrKeyNotFound.
+	DeleteMulti(keys []string) map[string]error
+
+	// Create a batch which queues Get/Set/Add/Delete/Increment/Touch/Append and executes them together.
+	NewBatch() *Batch
+
+	// Change the expiration of key without fetching it.
+	// Return value is CAS, the error is nil when operation is successful.
+	Touch(args *KeyArgs) (uint64, error)
+
 	// Appends data to the tail/head of an existing value.
 	// Return value is the CAS, and the error is nil when the operation is successful.
 	// This function does not serialize data
@@ -64,7 +187,7 @@ type Client interface {
 	Prepend(args *KeyArgs) (uint64, error)
 
 	// Atomic operation, the delta of the existing value is increased/decreased.
-	// If the key does not exist, the operation returns the initial value of the key.
+	// If the key does not exist, the key is created with `Initial` and the operation returns it.
 	// The error is nil when the operation is successful
 	Increment(args *KeyArgs) (uint64, uint64, error)
 	Decrement(args *KeyArgs) (uint64, uint64, error)
@@ -73,7 +196,34 @@ type Client interface {
 	// The error is nil when the operation is successful
 	TouchAtomicValue(key string) (uint64, error)
 
+	// Get the raw item of key, its value and flags are returned as they are stored.
+	GetItem(key string) (*Item, error)
+
+	// Store the raw value and flags of item, the item is only stored if its CAS is not changed when CAS is nonzero.
+	SetItem(item *Item) (uint64, error)
+
+	// Write the raw value of key to w while it is read from connection, the value is never buffered as a whole.
+	GetTo(key string, w io.Writer) (uint64, error)
+
+	// Store `size` bytes of r as the raw value of key while they are written to connection, `args.Value` is ignored.
+	SetFrom(args *KeyArgs, r io.Reader, size int64) (uint64, error)
+
+	// Set the value of key with the current versions of `tags`.
+	// Get treats the value as missing once any of the tags is invalidated.
+	SetWithTags(args *KeyArgs, tags ...string) (uint64, error)
+
+	// Invalidate every value stored with any of `tags`.
+	InvalidateTags(tags ...string) error
+
+	// Return a view of client which prefixes every key with `prefix` and the generation of namespace.
+	// `InvalidateNamespace` of the view drops every key of the namespace without Flush.
+	Namespace(prefix string) *Namespace
+
 	// Flush all items,
-	// flush the items in the cache now or some time in the future as specified by the expiration field
+	// flush the items in the cache now or some time in the future as specified by the expiration field.
+	// Servers are flushed concurrently, the error is a ServerErrors of the failed servers.
 	Flush(args *KeyArgs) error
+
+	// Flush all items of server `addr` now.
+	FlushServer(addr string) error
 }
@@ -0,0 +1,62 @@
+package gomemcached
+
+import "context"
+
+// Close rejects new operations with ErrClientClosed and waits for the in-flight operations until ctx is done,
+// then sends QUIT to every connection, closes it and stops the background goroutines.
+// The error is ctx.Err() when the in-flight operations did not finish in time, they fail when connections are closed.
+func (m *MemcachedClient) Close(ctx context.Context) error {
+	m.closeMu.Lock()
+	if m.closed {
+		m.closeMu.Unlock()
+		return ErrClientClosed
+	}
+	m.closed = true
+	drained := make(chan struct{})
+	if m.inflight <= 0 {
+		close(drained)
+	} else {
+		m.drained = drained
+	}
+	m.closeMu.Unlock()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	m.cluster.close()
+	return err
+}
+
+// enter counts an in-flight operation, it must be paired with leave.
+func (m *MemcachedClient) enter() error {
+	m.closeMu.Lock()
+	defer m.closeMu.Unlock()
+
+	if m.closed {
+		return ErrClientClosed
+	}
+	m.inflight++
+	return nil
+}
+
+func (m *MemcachedClient) leave() {
+	m.closeMu.Lock()
+	defer m.closeMu.Unlock()
+
+	m.inflight--
+	if m.inflight <= 0 && m.drained != nil {
+		close(m.drained)
+		m.drained = nil
+	}
+}
+
+func (m *MemcachedClient) isClosed() bool {
+	m.closeMu.Lock()
+	defer m.closeMu.Unlock()
+
+	return m.closed
+}
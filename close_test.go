@@ -0,0 +1,87 @@
+package gomemcached
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestClose(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	goroutines := runtime.NumGoroutine()
+
+	addrs := fakeServerAddrs(servers)
+	m := NewMemcachedClient(addrs, 2).(*MemcachedClient)
+	m.SetPipelining(1)
+	m.SetServerResolver(NewStaticResolver(addrs...), time.Second)
+
+	_, err := m.Set(&KeyArgs{Key: "TestClose", Value: "HelloWorld"})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+	if errs := m.SetMulti([]*KeyArgs{{Key: "TestClose1", Value: 1}, {Key: "TestClose2", Value: 2}}); len(errs) > 0 {
+		t.Fatalf("SetMulti errs: %v", errs)
+	}
+
+	conns := 0
+	for _, s := range servers {
+		conns += s.ConnCount()
+	}
+
+	// the in-flight operation is waited
+	for _, s := range servers {
+		s.SetDelay(time.Millisecond * 200)
+	}
+	getErr := make(chan error, 1)
+	go func() {
+		var value string
+		_, err := m.Get("TestClose", &value)
+		getErr <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	for _, s := range servers {
+		s.SetDelay(0)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Close(ctx); err != nil {
+		t.Fatalf("Close err: %v", err)
+	}
+	if err := <-getErr; err != nil {
+		t.Fatalf("in-flight Get err: %v", err)
+	}
+
+	var value string
+	if _, err := m.Get("TestClose", &value); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Get after Close: %v", err)
+	}
+	if _, err := m.Set(&KeyArgs{Key: "TestClose", Value: "HelloWorld"}); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Set after Close: %v", err)
+	}
+	if err := m.Close(ctx); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Close twice: %v", err)
+	}
+
+	quit := 0
+	for _, s := range servers {
+		quit += s.OpCount(OPCODE_QUIT)
+	}
+	if quit != conns {
+		t.Errorf("servers received %v QUIT from %v connections", quit, conns)
+	}
+
+	// goroutines of server connections and client exit asynchronously
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		buf := make([]byte, 1<<16)
+		t.Fatalf("%v goroutines leaked:\n%s", n-goroutines, buf[:runtime.Stack(buf, true)])
+	}
+}
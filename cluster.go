@@ -16,6 +16,8 @@ var (
 	NodeRepetitions       = 160
 	RingPosition          = 4
 	CommanderID     int64 = 10000
+	// How often idle connections are checked by NOOP.
+	HeartbeatInterval = time.Duration(3) * time.Second
 )
 
 type Server struct {
@@ -25,6 +27,18 @@ type Server struct {
 	cmders            map[int64]*Commander
 	badCmders         []*Commander
 	cluster           *Cluster
+	removed           bool
+	breaker           *circuitBreaker
+	pipes             []*Commander
+	nextPipe          int
+	pipeDialing       bool
+	// a pipelined connection is refused by server
+	unreachable bool
+	// all connections are broken, the server is kept on ring by the failure policy
+	dead      bool
+	deadSince time.Time
+	// no connection was accepted since the server was added, it is put on ring once it accepts connections
+	joining bool
 }
 
 type Cluster struct {
@@ -35,6 +49,16 @@ type Cluster struct {
 	quitF             context.CancelFunc
 	serverErrCallback ServerErrorCallback
 	badServerNoticer  chan *Server
+	maxConnPerServer  uint32
+	resolverQuitF     context.CancelFunc
+	breakerConfig     *BreakerConfig
+	breakerCallback   BreakerStateCallback
+	pipeConns         int
+	failurePolicy     FailurePolicy
+	deadGrace         time.Duration
+	closed            bool
+	// background goroutines
+	wg sync.WaitGroup
 	sync.RWMutex
 }
 
@@ -56,6 +80,7 @@ func createCluster(addrs []string, maxConnPerServer uint32) *Cluster {
 		hash2Servers:     make(map[uint32]*Server),
 		addr2Servers:     make(map[string]*Server, len(addrs)),
 		badServerNoticer: make(chan *Server),
+		maxConnPerServer: maxConnPerServer,
 	}
 
 	for _, addr := range addrs {
@@ -65,12 +90,13 @@ func createCluster(addrs []string, maxConnPerServer uint32) *Cluster {
 			cmders:            make(map[int64]*Commander, maxConnPerServer),
 			cluster:           cl,
 		}
-		cl.hashServer(s)
+		cl.hashServer(s, s.dialCmders())
 	}
 
 	sort.Sort(SortList(cl.nodeList))
 
 	cl.ctx, cl.quitF = context.WithCancel(context.Background())
+	cl.wg.Add(1)
 	go cl.checkClusterServerNode()
 	return cl
 }
@@ -98,79 +124,148 @@ func (s *Server) getCmder() (*Commander, error) {
 	return nil, ErrNoUsableConnection
 }
 func (s *Server) putCmder(cmder *Commander) {
-	if cmder != nil && !cmder.giveup {
-		s.cmders[cmder.ID] = cmder
+	if cmder == nil || cmder.giveup || cmder.pipe != nil {
+		return
+	}
+
+	// server has been removed from cluster while the commander was in use
+	if s.removed {
+		cmder.conn.Close()
+		return
+	}
+
+	s.cmders[cmder.ID] = cmder
+}
+
+func (s *Server) closeCmders() {
+	for ID, cmder := range s.cmders {
+		cmder.conn.Close()
+		delete(s.cmders, ID)
 	}
+	s.closePipes()
 }
 
-func (cl *Cluster) exit() {
+// close stops background goroutines, sends QUIT to every connection and closes it.
+func (cl *Cluster) close() {
+	cl.Lock()
+	if cl.closed {
+		cl.Unlock()
+		return
+	}
+	cl.closed = true
+
+	var cmders []*Commander
+	for _, s := range cl.addr2Servers {
+		// commanders still in use are closed when they are released
+		s.removed = true
+		for ID, cmder := range s.cmders {
+			cmders = append(cmders, cmder)
+			delete(s.cmders, ID)
+		}
+		for i, cmder := range s.pipes {
+			if cmder != nil {
+				cmders = append(cmders, cmder)
+			}
+			s.pipes[i] = nil
+		}
+	}
+	cl.Unlock()
+
 	cl.quitF()
+	cl.wg.Wait()
+
+	var wg sync.WaitGroup
+	for _, cmder := range cmders {
+		wg.Add(1)
+		go func(cmder *Commander) {
+			defer wg.Done()
+			cmder.quit()
+		}(cmder)
+	}
+	wg.Wait()
 }
 
-func (cl *Cluster) hashServer(s *Server) {
+// hashServer adds server with its dialed commanders to cluster, the server is put on ring unless it is joining.
+func (cl *Cluster) hashServer(s *Server, cmders []*Commander) {
 	cl.addr2Servers[s.Addr] = s
+	s.breaker = cl.newServerBreaker(s)
+	s.resetPipes(cl.pipeConns)
+	for _, cmder := range cmders {
+		s.cmders[cmder.ID] = cmder
+	}
+
 	for i := 0; i < NodeRepetitions/4; i++ {
 		hashs := KetamaHash(s.Addr, (uint32)(i))
 		s.VirtualHashs = append(s.VirtualHashs, hashs...)
-		cl.nodeList = append(cl.nodeList, hashs...)
-		for _, hashValue := range hashs {
-			cl.hash2Servers[hashValue] = s
-		}
 	}
 
-	for i := 0; i < int(s.MaxCommanderCount); i++ {
-		conn, err := connect(s.Addr)
-		if err == nil {
-			ID := atomic.AddInt64(&CommanderID, 1)
-			cmder := &Commander{
-				ID:   ID,
-				conn: conn,
-				rw: bufio.NewReadWriter(
-					bufio.NewReader(conn),
-					bufio.NewWriter(conn),
-				),
-				pool:   bytepool.New(24, 256),
-				server: s,
-				giveup: false,
-			}
-			s.cmders[ID] = cmder
-		}
+	if !s.joining {
+		cl.ringServer(s)
 	}
 }
 
-func (cl *Cluster) chooseServer(key string) *Server {
+// ringServer puts the virtual nodes of server on ring, the caller sorts nodeList.
+func (cl *Cluster) ringServer(s *Server) {
+	cl.nodeList = append(cl.nodeList, s.VirtualHashs...)
+	for _, hashValue := range s.VirtualHashs {
+		cl.hash2Servers[hashValue] = s
+	}
+}
+
+func (s *Server) newCmder(conn net.Conn) *Commander {
+	return &Commander{
+		ID:   atomic.AddInt64(&CommanderID, 1),
+		conn: conn,
+		rw: bufio.NewReadWriter(
+			bufio.NewReader(conn),
+			bufio.NewWriter(conn),
+		),
+		pool:   bytepool.New(24, 256),
+		server: s,
+		giveup: false,
+	}
+}
+
+func (cl *Cluster) chooseNodeIndex(key string) int {
 	if len(cl.nodeList) <= 0 {
-		return nil
+		return -1
 	}
 
-	var targetHash uint32
 	hashValue := MakeHash(key)
 	if hashValue > cl.nodeList[len(cl.nodeList)-1] {
-		targetHash = cl.nodeList[0]
-	} else {
-		l := 0
-		r := len(cl.nodeList)
-		i := 0
-		for {
-			mid := (l + r) / 2
-			if hashValue == cl.nodeList[mid] {
-				i = mid
-				break
-			} else if hashValue > cl.nodeList[mid] {
-				l = mid
-			} else {
-				r = mid
-			}
+		return 0
+	}
 
-			if r-l == 1 {
-				i = r
-				break
-			}
+	l := 0
+	r := len(cl.nodeList)
+	i := 0
+	for {
+		mid := (l + r) / 2
+		if hashValue == cl.nodeList[mid] {
+			i = mid
+			break
+		} else if hashValue > cl.nodeList[mid] {
+			l = mid
+		} else {
+			r = mid
 		}
 
-		targetHash = cl.nodeList[i]
+		if r-l == 1 {
+			i = r
+			break
+		}
 	}
 
+	return i
+}
+
+func (cl *Cluster) chooseServer(key string) *Server {
+	i := cl.chooseNodeIndex(key)
+	if i < 0 {
+		return nil
+	}
+
+	targetHash := cl.nodeList[i]
 	if targetHash <= 0 {
 		return nil
 	}
@@ -183,42 +278,163 @@ func (cl *Cluster) chooseServer(key string) *Server {
 	return s
 }
 
+// chooseServers walks the ring clockwise from the node of key,
+// returns at most `count` distinct servers, the first one is the server `chooseServer` returns.
+func (cl *Cluster) chooseServers(key string, count int) []*Server {
+	i := cl.chooseNodeIndex(key)
+	if i < 0 || count <= 0 {
+		return nil
+	}
+
+	if count > len(cl.addr2Servers) {
+		count = len(cl.addr2Servers)
+	}
+
+	servers := make([]*Server, 0, count)
+	for n := 0; n < len(cl.nodeList) && len(servers) < count; n++ {
+		s, ok := cl.hash2Servers[cl.nodeList[(i+n)%len(cl.nodeList)]]
+		if !ok {
+			panic("Virtual node not found in Cluster")
+		}
+
+		found := false
+		for _, v := range servers {
+			if v == s {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			servers = append(servers, s)
+		}
+	}
+
+	return servers
+}
+
+func (cl *Cluster) ChooseServersByKey(key string, count int) []*Server {
+	cl.RLock()
+	defer cl.RUnlock()
+
+	return cl.chooseServers(key, count)
+}
+
+// groupKeysByServer groups the indexes of keys by server, every key belongs to `count` distinct servers,
+// the second return value is the number of servers every key belongs to, empty keys are skipped.
+func (cl *Cluster) groupKeysByServer(keys []string, count int) (map[*Server][]int, []int) {
+	cl.RLock()
+	defer cl.RUnlock()
+
+	if count < 1 {
+		count = 1
+	}
+
+	groups := make(map[*Server][]int)
+	counts := make([]int, len(keys))
+	for i, key := range keys {
+		if len(key) <= 0 {
+			continue
+		}
+
+		servers := cl.chooseServers(key, count)
+		counts[i] = len(servers)
+		for _, s := range servers {
+			groups[s] = append(groups[s], i)
+		}
+	}
+
+	return groups, counts
+}
+
 func (cl *Cluster) ChooseServerCommanderByServerAddr(addr string) (*Server, *Commander, error) {
 	cl.Lock()
 	defer cl.Unlock()
 
+	if cl.closed {
+		return nil, nil, ErrClientClosed
+	}
+
 	s, ok := cl.addr2Servers[addr]
 	if !ok {
 		return nil, nil, ErrInvalidArguments
 	}
 
-	var target *Commander
-	for _, cmder := range s.cmders {
-		target = cmder
-		break
+	cmder, err := cl.checkoutCmder(s, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s, cmder, nil
+}
+
+// ChooseServerPooledCommander returns a commander from the pool of server even if pipelining is enabled,
+// the commander owns its connection exclusively until it is released.
+func (cl *Cluster) ChooseServerPooledCommander(addr string) (*Server, *Commander, error) {
+	cl.Lock()
+	defer cl.Unlock()
+
+	if cl.closed {
+		return nil, nil, ErrClientClosed
+	}
+
+	s, ok := cl.addr2Servers[addr]
+	if !ok {
+		return nil, nil, ErrInvalidArguments
 	}
 
-	if target != nil {
-		delete(s.cmders, target.ID)
-		return s, target, nil
+	cmder, err := cl.checkoutCmder(s, true)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil, nil, ErrNoUsableConnection
+	return s, cmder, nil
 }
 
 func (cl *Cluster) ChooseServerCommanderByKey(key string) (*Server, *Commander, error) {
+	return cl.chooseCommanderByKey(key, false)
+}
+
+// chooseCommanderByKey returns a commander of the server of key,
+// `read` allows the fallback to the next server on ring when the server is dead under FailureReadOnly.
+func (cl *Cluster) chooseCommanderByKey(key string, read bool) (*Server, *Commander, error) {
 	cl.Lock()
 	defer cl.Unlock()
 
+	if cl.closed {
+		return nil, nil, ErrClientClosed
+	}
+
 	s := cl.chooseServer(key)
 	if s == nil {
 		return nil, nil, ErrInvalidArguments
 	}
 
-	cmder, err := s.getCmder()
-	if err == ErrNoUsableConnection {
+	cmder, err := cl.checkoutCmder(s, false)
+	if err == ErrCircuitOpen && cl.breakerConfig != nil && cl.breakerConfig.Reroute {
+		for _, next := range cl.chooseServers(key, len(cl.addr2Servers))[1:] {
+			cmder, err = cl.checkoutCmder(next, false)
+			if err != ErrCircuitOpen && err != ErrServerUnavailable {
+				s = next
+				break
+			}
+		}
+	}
+
+	if err == ErrServerUnavailable && read && cl.failurePolicy == FailureReadOnly {
+		for _, next := range cl.chooseServers(key, len(cl.addr2Servers))[1:] {
+			if !next.dead {
+				s = next
+				cmder, err = cl.checkoutCmder(next, false)
+				break
+			}
+		}
+	}
+
+	// keys are only moved to other servers under FailureRehash
+	if err == ErrNoUsableConnection && cl.failurePolicy == FailureRehash {
 		for _, s = range cl.addr2Servers {
-			cmder, err = s.getCmder()
+			cmder, err = cl.checkoutCmder(s, false)
 			if err == nil {
 				return s, cmder, nil
 			}
@@ -228,6 +444,35 @@ func (cl *Cluster) ChooseServerCommanderByKey(key string) (*Server, *Commander,
 	return s, cmder, err
 }
 
+// checkoutCmder gets a commander of server if it is not dead and its circuit breaker allows,
+// the commander is pipelined when pipelining is enabled unless `pooled` is true.
+func (cl *Cluster) checkoutCmder(s *Server, pooled bool) (*Commander, error) {
+	if s.dead {
+		return nil, ErrServerUnavailable
+	}
+
+	generation, err := s.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	var cmder *Commander
+	if len(s.pipes) > 0 && !pooled {
+		cmder, err = s.getPipeCmder()
+	}
+	// pooled connections serve while the pipelined connections are dialed
+	if cmder == nil {
+		cmder, err = s.getCmder()
+	}
+	if err != nil {
+		s.release(generation)
+		return nil, err
+	}
+
+	cmder.breakerGen = generation
+	return cmder, nil
+}
+
 func (cl *Cluster) ReleaseServerCommander(s *Server, cmder *Commander) {
 	cl.Lock()
 	defer cl.Unlock()
@@ -235,25 +480,122 @@ func (cl *Cluster) ReleaseServerCommander(s *Server, cmder *Commander) {
 	s.putCmder(cmder)
 }
 
-func (cl *Cluster) AddServer2Cluster(addr string, maxConnPerServer uint32) error {
+// renewCmder replaces the connection of commander which caller left in the middle of a request,
+// the server is only taken as bad when it refuses the new connection.
+func (cl *Cluster) renewCmder(s *Server, cmder *Commander) {
+	// pipelined connection is still in order, only the handle is dropped
+	if cmder.pipe != nil {
+		return
+	}
+
+	conn, err := connect(s.Addr)
+	if err != nil {
+		cmder.Giveup()
+		return
+	}
+
+	cmder.conn.Close()
+	cmder.giveup = true
+
 	cl.Lock()
 	defer cl.Unlock()
+	s.putCmder(s.newCmder(conn))
+}
 
-	if _, ok := cl.addr2Servers[addr]; ok {
-		return ErrServerAlreadyInCluster
+// AddServer2Cluster dials the server outside the cluster lock, then adds it to cluster.
+// A server which refuses connections is kept off ring and reconnected on every heartbeat until it accepts them.
+func (cl *Cluster) AddServer2Cluster(addr string, maxConnPerServer uint32) error {
+	if err := cl.checkNewServer(addr); err != nil {
+		return err
 	}
 
 	s := &Server{
 		Addr:              addr,
 		MaxCommanderCount: maxConnPerServer,
 		cmders:            make(map[int64]*Commander, maxConnPerServer),
+		cluster:           cl,
 	}
+	// dialing may last ConnectTimeout, the cluster is not locked meanwhile
+	cmders := s.dialCmders()
 
-	cl.hashServer(s)
+	cl.Lock()
+	defer cl.Unlock()
+
+	// the cluster may be closed or the server may be added by another caller while dialing
+	if err := cl.checkNewServerLocked(addr); err != nil {
+		for _, cmder := range cmders {
+			cmder.conn.Close()
+		}
+		return err
+	}
+
+	if len(cmders) <= 0 {
+		s.joining = true
+		s.dead = true
+		s.deadSince = time.Now()
+	}
+
+	cl.hashServer(s, cmders)
+	sort.Sort(SortList(cl.nodeList))
 
 	return nil
 }
 
+func (cl *Cluster) checkNewServer(addr string) error {
+	cl.RLock()
+	defer cl.RUnlock()
+
+	return cl.checkNewServerLocked(addr)
+}
+
+func (cl *Cluster) checkNewServerLocked(addr string) error {
+	if cl.closed {
+		return ErrClientClosed
+	}
+
+	if _, ok := cl.addr2Servers[addr]; ok {
+		return ErrServerAlreadyInCluster
+	}
+
+	return nil
+}
+
+func (cl *Cluster) RemoveServerFromCluster(addr string) error {
+	cl.Lock()
+	defer cl.Unlock()
+
+	s, ok := cl.addr2Servers[addr]
+	if !ok {
+		return ErrNotFoundServerNode
+	}
+
+	cl.cleanBadServer(s)
+	cl.rebuildNodeList()
+	s.removed = true
+	s.closeCmders()
+
+	return nil
+}
+
+// applyServerList diff addrs against servers in cluster,
+// add the new servers and remove the servers which are not in addrs.
+func (cl *Cluster) applyServerList(addrs []string) {
+	wanted := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		wanted[addr] = struct{}{}
+	}
+
+	for _, addr := range cl.getServerAddrs() {
+		if _, ok := wanted[addr]; !ok {
+			cl.RemoveServerFromCluster(addr)
+		}
+	}
+
+	for addr := range wanted {
+		cl.AddServer2Cluster(addr, cl.maxConnPerServer)
+	}
+}
+
 func (cl *Cluster) getServerAddrs() []string {
 	var addrs []string
 
@@ -267,41 +609,62 @@ func (cl *Cluster) getServerAddrs() []string {
 }
 
 func (cl *Cluster) checkClusterServerNode() {
-	heartbeatTimer := time.After(time.Second * 3)
+	defer cl.wg.Done()
+
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-cl.ctx.Done():
 			return
 		case s := <-cl.badServerNoticer:
 			cl.doCheckServer(s)
-		case <-heartbeatTimer:
+		case <-ticker.C:
 			cl.doCheckHeartbeat()
-		default:
+			cl.doCheckDeadServers()
 		}
 	}
 }
 
 func (cl *Cluster) doCheckServer(s *Server) {
-	if len(s.badCmders) >= int(s.MaxCommanderCount) {
-		cl.Lock()
-		defer cl.Unlock()
+	cl.Lock()
+	defer cl.Unlock()
 
-		cl.cleanBadServer(s)
-		if cl.serverErrCallback != nil {
-			cl.serverErrCallback(s.Addr)
-		}
+	if s.dead || (len(s.badCmders) < int(s.MaxCommanderCount) && !s.unreachable) {
+		return
+	}
+
+	if cl.serverErrCallback != nil {
+		cl.serverErrCallback(s.Addr)
+	}
+
+	// the server stays on ring until it recovers, or until the grace window ends under FailureRehash
+	if cl.failurePolicy != FailureRehash || cl.deadGrace > 0 {
+		cl.markServerDead(s)
+		return
+	}
+
+	cl.cleanBadServer(s)
+	cl.rebuildNodeList()
+}
 
-		// rebuild nodeList
-		nodeList := cl.nodeList[:0]
-		for _, s := range cl.addr2Servers {
+func (cl *Cluster) rebuildNodeList() {
+	nodeList := cl.nodeList[:0]
+	for _, s := range cl.addr2Servers {
+		if !s.joining {
 			nodeList = append(nodeList, s.VirtualHashs...)
 		}
-		cl.nodeList = nodeList
-		sort.Sort(SortList(cl.nodeList))
 	}
+	cl.nodeList = nodeList
+	sort.Sort(SortList(cl.nodeList))
 }
 
 func (cl *Cluster) cleanBadServer(s *Server) {
+	// server was already removed, maybe replaced by a new server with same address
+	if cl.addr2Servers[s.Addr] != s {
+		return
+	}
+
 	// remove sever from c.servers
 	for _, v := range s.VirtualHashs {
 		delete(cl.hash2Servers, v)
@@ -312,12 +675,35 @@ func (cl *Cluster) cleanBadServer(s *Server) {
 }
 
 func (cl *Cluster) doCheckHeartbeat() {
+	var idle []*Commander
 	cl.RLock()
-	defer cl.RUnlock()
-
 	for _, server := range cl.addr2Servers {
 		for _, cmder := range server.cmders {
-			cmder.noop()
+			idle = append(idle, cmder)
+		}
+	}
+	cl.RUnlock()
+
+	for _, cmder := range idle {
+		// the commander is checked out one at a time, so NOOP is not sent under the cluster lock
+		s := cmder.server
+		cl.Lock()
+		if s.cmders[cmder.ID] != cmder {
+			cl.Unlock()
+			continue
 		}
+		delete(s.cmders, cmder.ID)
+		cl.Unlock()
+
+		if err := cmder.noop(); err != nil {
+			// the late response of NOOP would be read by the next operation on the connection,
+			// the checker is noticed directly because this is its own goroutine
+			if cmder.discard() {
+				cl.doCheckServer(s)
+			}
+			continue
+		}
+
+		cl.ReleaseServerCommander(s, cmder)
 	}
 }
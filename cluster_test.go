@@ -5,6 +5,8 @@ import (
 	"math/rand"
 	"testing"
 	"time"
+
+	"github.com/shaoyuan1943/gomemcached/internal/fakeserver"
 )
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890")
@@ -63,3 +65,59 @@ func TestCluster_FindServerByKey(t *testing.T) {
 		fmt.Printf("%v\t%v\n", k, v)
 	}
 }
+
+func startFakeServers(t testing.TB, n int) []*fakeserver.Server {
+	var servers []*fakeserver.Server
+	for i := 0; i < n; i++ {
+		s, err := fakeserver.Start()
+		if err != nil {
+			t.Fatalf("start fake server err: %v", err)
+		}
+		servers = append(servers, s)
+	}
+
+	return servers
+}
+
+func closeFakeServers(servers []*fakeserver.Server) {
+	for _, s := range servers {
+		s.Close()
+	}
+}
+
+func fakeServerAddrs(servers []*fakeserver.Server) []string {
+	var addrs []string
+	for _, s := range servers {
+		addrs = append(addrs, s.Addr())
+	}
+	return addrs
+}
+
+func TestHeartbeatTimeout(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+	defer fastHeartbeat()()
+
+	readTimeout := ReadTimeout
+	ReadTimeout = time.Millisecond * 100
+	defer func() { ReadTimeout = readTimeout }()
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetFailurePolicy(FailureFailFast, 0)
+
+	if _, err := m.Set(&KeyArgs{Key: "TestHeartbeat", Value: "HelloWorld"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	// the heartbeats time out, their connections must not be reused
+	servers[0].SetDelay(time.Millisecond * 150)
+	time.Sleep(time.Millisecond * 300)
+	servers[0].SetDelay(0)
+
+	waitFor(t, "Get after heartbeat timeout", func() bool {
+		var value string
+		_, err := m.Get("TestHeartbeat", &value)
+		return err == nil && value == "HelloWorld"
+	})
+}
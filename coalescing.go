@@ -0,0 +1,53 @@
+package gomemcached
+
+import "sync"
+
+type flightCall struct {
+	wg   sync.WaitGroup
+	flag uint32
+	data []byte
+	cas  uint64
+	err  error
+}
+
+// flightGroup coalesces concurrent fetches of the same key into one request,
+// the callers share the raw result and decode it into their own value.
+type flightGroup struct {
+	calls map[string]*flightCall
+	sync.Mutex
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+func (g *flightGroup) do(key string, fetch func() (uint32, []byte, uint64, error)) (uint32, []byte, uint64, error) {
+	g.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.Unlock()
+		call.wg.Wait()
+		return call.flag, call.data, call.cas, call.err
+	}
+
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.Unlock()
+
+	call.flag, call.data, call.cas, call.err = fetch()
+
+	g.Lock()
+	delete(g.calls, key)
+	g.Unlock()
+	call.wg.Done()
+
+	return call.flag, call.data, call.cas, call.err
+}
+
+func (m *MemcachedClient) SetGetCoalescing(enable bool) {
+	if enable {
+		m.flights = newFlightGroup()
+	} else {
+		m.flights = nil
+	}
+}
@@ -0,0 +1,53 @@
+package gomemcached
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetCoalescing(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetGetCoalescing(true)
+
+	_, err := m.Set(&KeyArgs{Key: "TestGetCoalescing", Value: &Person{Name: "lennon", Age: 29}})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+	servers[0].SetDelay(time.Millisecond * 100)
+
+	start := make(chan struct{})
+	persons := make([]Person, 50)
+	errs := make([]error, len(persons))
+	var wg sync.WaitGroup
+	for i := range persons {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			_, errs[i] = m.Get("TestGetCoalescing", &persons[i])
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i := range persons {
+		if errs[i] != nil || persons[i].Name != "lennon" {
+			t.Fatalf("Get %v: %v, %v", i, persons[i], errs[i])
+		}
+	}
+
+	// every caller owns its value
+	persons[0].Name = "yoko"
+	if persons[1].Name != "lennon" {
+		t.Fatalf("decoded values are shared")
+	}
+
+	if n := servers[0].OpCount(OPCODE_GET); n > 2 {
+		t.Errorf("server received %v gets", n)
+	}
+}
@@ -3,6 +3,7 @@ package gomemcached
 import (
 	"bufio"
 	"encoding/binary"
+	"fmt"
 	"net"
 	"strconv"
 	"time"
@@ -25,6 +26,20 @@ type Commander struct {
 	pool   *bytepool.Pool
 	server *Server
 	giveup bool
+	// generation of server's circuit breaker when commander was checked out
+	breakerGen uint64
+	// not nil when the connection is pipelined and shared by many commanders
+	pipe *pipeline
+	// closing it drops the pending request of a pipelined commander
+	cancel <-chan struct{}
+}
+
+type response struct {
+	body   *bytebufferpool.ByteBuffer
+	extLen uint8
+	status uint16
+	opaque uint32
+	cas    uint64
 }
 
 func newCommander(ID int64, conn net.Conn, s *Server) *Commander {
@@ -41,6 +56,10 @@ func newCommander(ID int64, conn net.Conn, s *Server) *Commander {
 }
 
 func (cmder *Commander) wait4Rsp(req *bytebufferpool.ByteBuffer) (*bytebufferpool.ByteBuffer, uint8, uint64, error) {
+	if cmder.pipe != nil {
+		return cmder.pipe.roundTrip(req, cmder.cancel)
+	}
+
 	if err := cmder.write(req); err != nil {
 		return nil, 0, 0, err
 	}
@@ -49,62 +68,128 @@ func (cmder *Commander) wait4Rsp(req *bytebufferpool.ByteBuffer) (*bytebufferpoo
 		return nil, 0, 0, err
 	}
 
-	rsp := bytebufferpool.Get()
-	defer bytebufferpool.Put(rsp)
+	rsp, err := cmder.readResponse()
+	if err != nil {
+		return nil, 0, 0, err
+	}
 
-	rsp.Reset()
-	if _, err := cmder.readN(rsp, RSP_HEADER_LEN); err != nil {
+	if err := rsp.statusErr(); err != nil {
+		bytebufferpool.Put(rsp.body)
 		return nil, 0, 0, err
 	}
 
-	extLen := rsp.B[4]
-	status := binary.BigEndian.Uint16(rsp.B[6:8])
-	bodyLen := binary.BigEndian.Uint32(rsp.B[8:12])
-	cas := binary.BigEndian.Uint64(rsp.B[16:24])
+	return rsp.body, rsp.extLen, rsp.cas, nil
+}
 
-	body := bytebufferpool.Get()
+// statusErr returns the error of response status with the error message of server.
+func (rsp *response) statusErr() error {
+	err := checkStatus(rsp.status)
+	if err == nil {
+		return nil
+	}
+
+	var msg string
+	if body := rsp.body.Bytes(); int(rsp.extLen) <= len(body) {
+		msg = string(body[rsp.extLen:])
+	}
+	return &OpError{Status: rsp.status, ServerMsg: msg, Err: err}
+}
+
+// readResponse reads the header and body of a response,
+// the body must be put back to pool by caller when error is nil.
+func (cmder *Commander) readResponse() (response, error) {
+	rsp, _, bodyLen, err := cmder.readHeader()
+	if err != nil {
+		return response{}, err
+	}
+
+	rsp.body = bytebufferpool.Get()
 	if bodyLen > 0 {
-		if _, err := cmder.readN(body, (int)(bodyLen)); err != nil {
-			bytebufferpool.Put(body)
-			return nil, 0, 0, err
+		if _, err := cmder.readN(rsp.body, (int)(bodyLen)); err != nil {
+			bytebufferpool.Put(rsp.body)
+			return response{}, err
 		}
 	}
 
-	if err := checkStatus(status); err != nil {
-		return nil, 0, 0, err
+	return rsp, nil
+}
+
+// readHeader reads the header of a response, the returned response has no body.
+// Return values are the response, the key length and the body length.
+func (cmder *Commander) readHeader() (response, uint16, uint32, error) {
+	header := bytebufferpool.Get()
+	defer bytebufferpool.Put(header)
+
+	header.Reset()
+	if _, err := cmder.readN(header, RSP_HEADER_LEN); err != nil {
+		return response{}, 0, 0, err
 	}
 
-	return body, extLen, cas, nil
+	if header.B[0] != MAGIC_RESPONSE {
+		return response{}, 0, 0, fmt.Errorf("%w: bad magic 0x%02x of response", ErrProtocol, header.B[0])
+	}
+
+	rsp := response{
+		extLen: header.B[4],
+		status: binary.BigEndian.Uint16(header.B[6:8]),
+		opaque: binary.BigEndian.Uint32(header.B[12:16]),
+		cas:    binary.BigEndian.Uint64(header.B[16:24]),
+	}
+	keyLen := binary.BigEndian.Uint16(header.B[2:4])
+	bodyLen := binary.BigEndian.Uint32(header.B[8:12])
+	return rsp, keyLen, bodyLen, nil
 }
 
 func (cmder *Commander) store(opCode uint8, args *KeyArgs) (uint64, error) {
 	req := bytebufferpool.Get()
 	defer bytebufferpool.Put(req)
 
+	if err := writeStoreReq(req, opCode, args, 0x00); err != nil {
+		return 0, err
+	}
+
+	body, _, modifyCAS, err := cmder.wait4Rsp(req)
+	defer func() {
+		if body != nil {
+			bytebufferpool.Put(body)
+		}
+	}()
+
+	return modifyCAS, err
+}
+
+// writeStoreReq appends a Set/Add/Replace request of args to req,
+// nothing is appended when the value can not be encoded.
+func writeStoreReq(req *bytebufferpool.ByteBuffer, opCode uint8, args *KeyArgs, opaque uint32) error {
 	var encoder Encoder
 	var rawValue []byte
 	var err error
 	if args.useMsgpack {
 		// type value --> raw value
 		encoder = getEncoder()
+		defer putEncoder(encoder)
 		rawValue, err = encoder.Encode(args.Value)
 		if err != nil {
-			return 0, ErrMarshalFailed
+			return ErrMarshalFailed
 		}
 	} else {
-		rawValue = args.Value.([]byte)
+		var ok bool
+		rawValue, ok = args.Value.([]byte)
+		if !ok {
+			return ErrCommandArgumentsInvalid
+		}
 	}
 
 	// request header
 	writeReqHeader(req, MAGIC_REQUEST, opCode, (uint16)(len(args.Key)), 0x08, RAW_DATA, 0x00,
-		uint32(0x08+len(args.Key)+len(rawValue)), 0x00, args.CAS)
+		uint32(0x08+len(args.Key)+len(rawValue)), opaque, args.CAS)
 
 	// extra:8byte |----flag:4----|----expiration:4----|
-	if args.useMsgpack {
-		WriteUint32(req, (uint32)(USE_MSGP_FLAG))
-	} else {
-		WriteUint32(req, 0)
+	flag := args.flag
+	if args.useMsgpack && flag == 0 {
+		flag = USE_MSGP_FLAG
 	}
+	WriteUint32(req, flag)
 	WriteUint32(req, args.Expiration)
 	// extra end
 
@@ -113,31 +198,51 @@ func (cmder *Commander) store(opCode uint8, args *KeyArgs) (uint64, error) {
 	// value
 	req.Write(rawValue)
 
-	if encoder != nil {
-		putEncoder(encoder)
-	}
+	return nil
+}
 
-	body, _, modifyCAS, err := cmder.wait4Rsp(req)
+// get decodes the value of key into `value`, and returns its CAS and the tag versions it was stored with.
+func (cmder *Commander) get(key string, value interface{}) (uint64, map[string]uint64, error) {
+	req := bytebufferpool.Get()
+	defer bytebufferpool.Put(req)
+
+	writeGetReq(req, OPCODE_GET, key, 0x00)
+
+	// flush to memcached server
+	body, extLen, cas, err := cmder.wait4Rsp(req)
 	defer func() {
 		if body != nil {
 			bytebufferpool.Put(body)
 		}
 	}()
+	if err != nil {
+		return 0, nil, err
+	}
 
-	return modifyCAS, err
-}
+	flag := binary.BigEndian.Uint32(body.Bytes()[:extLen])
+	tags, err := decodeValueTags(flag, body.Bytes()[extLen:], value)
+	if err != nil {
+		return 0, nil, err
+	}
 
-func (cmder *Commander) get(key string, value interface{}) (uint64, error) {
-	req := bytebufferpool.Get()
-	defer bytebufferpool.Put(req)
+	return cas, tags, nil
+}
 
+func writeGetReq(req *bytebufferpool.ByteBuffer, opCode uint8, key string, opaque uint32) {
 	// request header
-	writeReqHeader(req, MAGIC_REQUEST, OPCODE_GET, (uint16)(len(key)), 0x00, RAW_DATA, 0x00,
-		(uint32)(len(key)), 0x00, 0x00)
+	writeReqHeader(req, MAGIC_REQUEST, opCode, (uint16)(len(key)), 0x00, RAW_DATA, 0x00,
+		(uint32)(len(key)), opaque, 0x00)
 	// key
 	req.WriteString(key)
+}
+
+// getRaw returns the flag, a copy of raw value and CAS of key.
+func (cmder *Commander) getRaw(key string) (uint32, []byte, uint64, error) {
+	req := bytebufferpool.Get()
+	defer bytebufferpool.Put(req)
+
+	writeGetReq(req, OPCODE_GET, key, 0x00)
 
-	// flush to memcached server
 	body, extLen, cas, err := cmder.wait4Rsp(req)
 	defer func() {
 		if body != nil {
@@ -145,28 +250,39 @@ func (cmder *Commander) get(key string, value interface{}) (uint64, error) {
 		}
 	}()
 	if err != nil {
-		return 0, err
+		return 0, nil, 0, err
 	}
 
 	flag := binary.BigEndian.Uint32(body.Bytes()[:extLen])
+	data := make([]byte, len(body.Bytes())-int(extLen))
+	copy(data, body.Bytes()[extLen:])
+	return flag, data, cas, nil
+}
+
+func decodeValue(flag uint32, data []byte, value interface{}) error {
+	if flag == USE_TAGGED_FLAG {
+		_, err := decodeTaggedValue(data, value)
+		return err
+	}
+
 	if flag == USE_MSGP_FLAG {
 		decoder := getDecoder()
 		defer putDecoder(decoder)
-		err = decoder.Decode(body.Bytes()[extLen:], value)
+		err := decoder.Decode(data, value)
 		if err != nil {
-			return 0, ErrUnmarshalFailed
+			return ErrUnmarshalFailed
 		}
 	} else {
 		switch value.(type) {
 		case *[]byte:
 			v := value.(*[]byte)
-			*v = append((*v), body.Bytes()[extLen:]...)
+			*v = append((*v), data...)
 		default:
-			return 0, ErrTypeInvalid
+			return ErrTypeInvalid
 		}
 	}
 
-	return cas, nil
+	return nil
 }
 
 func (cmder *Commander) noop() error {
@@ -187,16 +303,32 @@ func (cmder *Commander) noop() error {
 	return err
 }
 
-func (cmder *Commander) delete(key string, cas uint64) error {
+// quit sends QUIT and closes the connection.
+func (cmder *Commander) quit() {
 	req := bytebufferpool.Get()
 	defer bytebufferpool.Put(req)
 
 	// request header
-	writeReqHeader(req, MAGIC_REQUEST, OPCODE_DEL, uint16(len(key)), 0x00, RAW_DATA, 0x00,
-		uint32(len(key)), 0x00, 0x00)
+	writeReqHeader(req, MAGIC_REQUEST, OPCODE_QUIT, 0x00, 0x00, RAW_DATA, 0x00,
+		0x00, 0x00, 0x00)
 
-	// key
-	req.WriteString(key)
+	body, _, _, _ := cmder.wait4Rsp(req)
+	if body != nil {
+		bytebufferpool.Put(body)
+	}
+
+	if cmder.pipe != nil {
+		cmder.pipe.close()
+	} else {
+		cmder.conn.Close()
+	}
+}
+
+func (cmder *Commander) delete(key string, cas uint64) error {
+	req := bytebufferpool.Get()
+	defer bytebufferpool.Put(req)
+
+	writeDeleteReq(req, OPCODE_DEL, key, cas, 0x00)
 
 	body, _, _, err := cmder.wait4Rsp(req)
 	defer func() {
@@ -208,22 +340,85 @@ func (cmder *Commander) delete(key string, cas uint64) error {
 	return err
 }
 
-func (cmder *Commander) append(opCode uint8, args *KeyArgs) (uint64, error) {
-	value, ok := args.Value.([]byte)
-	if !ok {
-		return 0, ErrCommandArgumentsInvalid
+func writeDeleteReq(req *bytebufferpool.ByteBuffer, opCode uint8, key string, cas uint64, opaque uint32) {
+	// request header
+	writeReqHeader(req, MAGIC_REQUEST, opCode, uint16(len(key)), 0x00, RAW_DATA, 0x00,
+		uint32(len(key)), opaque, cas)
+
+	// key
+	req.WriteString(key)
+}
+
+// quietBatch sends the quiet requests in req followed by a NOOP whose opaque is `count`.
+// Opaques of the requests are their indexes, the quiet requests only answer failures(or hits of Get),
+// so the NOOP response means every request has been executed.
+// `handle` is called with every other response, the body must not be retained.
+func (cmder *Commander) quietBatch(req *bytebufferpool.ByteBuffer, count uint32, handle func(rsp *response)) error {
+	writeReqHeader(req, MAGIC_REQUEST, OPCODE_NOOP, 0x00, 0x00, RAW_DATA, 0x00,
+		0x00, count, 0x00)
+
+	if err := cmder.write(req); err != nil {
+		return err
+	}
+
+	if err := cmder.flush2Server(); err != nil {
+		return err
 	}
 
+	for {
+		rsp, err := cmder.readResponse()
+		if err != nil {
+			return err
+		}
+
+		if rsp.opaque == count {
+			bytebufferpool.Put(rsp.body)
+			return nil
+		}
+
+		if rsp.opaque > count {
+			bytebufferpool.Put(rsp.body)
+			return fmt.Errorf("%w: unexpected opaque %v of quiet response", ErrProtocol, rsp.opaque)
+		}
+
+		handle(&rsp)
+		bytebufferpool.Put(rsp.body)
+	}
+}
+
+func (cmder *Commander) touch(args *KeyArgs) (uint64, error) {
 	req := bytebufferpool.Get()
 	defer bytebufferpool.Put(req)
 
+	writeTouchReq(req, args, 0x00)
+
+	body, _, modifyCAS, err := cmder.wait4Rsp(req)
+	defer func() {
+		if body != nil {
+			bytebufferpool.Put(body)
+		}
+	}()
+
+	return modifyCAS, err
+}
+
+func writeTouchReq(req *bytebufferpool.ByteBuffer, args *KeyArgs, opaque uint32) {
 	// request header
-	writeReqHeader(req, MAGIC_REQUEST, opCode, uint16(len(args.Key)), 0x00, RAW_DATA, 0x00,
-		uint32(len(args.Key)+len(value)), 0x00, args.CAS)
+	writeReqHeader(req, MAGIC_REQUEST, OPCODE_TOUCH, uint16(len(args.Key)), 0x04, RAW_DATA, 0x00,
+		uint32(len(args.Key)+0x04), opaque, 0x00)
+	// extra:4byte |----expiration:4----|
+	WriteUint32(req, args.Expiration)
 	// key
 	req.WriteString(args.Key)
-	// value
-	req.Write(value)
+}
+
+func (cmder *Commander) append(opCode uint8, args *KeyArgs) (uint64, error) {
+	req := bytebufferpool.Get()
+	defer bytebufferpool.Put(req)
+
+	if err := writeAppendReq(req, opCode, args, 0x00); err != nil {
+		return 0, err
+	}
 
 	body, _, modifyCAS, err := cmder.wait4Rsp(req)
 	defer func() {
@@ -235,24 +430,28 @@ func (cmder *Commander) append(opCode uint8, args *KeyArgs) (uint64, error) {
 	return modifyCAS, err
 }
 
-func (cmder *Commander) atomic(opCode uint8, args *KeyArgs) (uint64, uint64, error) {
-	extData := bytebufferpool.Get()
-	defer bytebufferpool.Put(extData)
+func writeAppendReq(req *bytebufferpool.ByteBuffer, opCode uint8, args *KeyArgs, opaque uint32) error {
+	value, ok := args.Value.([]byte)
+	if !ok {
+		return ErrCommandArgumentsInvalid
+	}
+
+	// request header
+	writeReqHeader(req, MAGIC_REQUEST, opCode, uint16(len(args.Key)), 0x00, RAW_DATA, 0x00,
+		uint32(len(args.Key)+len(value)), opaque, args.CAS)
+	// key
+	req.WriteString(args.Key)
+	// value
+	req.Write(value)
 
-	WriteUint64(extData, args.Delta)
-	WriteUint64(extData, 0x0000000000000000)
-	WriteUint32(extData, args.Expiration)
+	return nil
+}
 
+func (cmder *Commander) atomic(opCode uint8, args *KeyArgs) (uint64, uint64, error) {
 	req := bytebufferpool.Get()
 	defer bytebufferpool.Put(req)
 
-	// request header
-	writeReqHeader(req, MAGIC_REQUEST, opCode, uint16(len(args.Key)), 0x14, RAW_DATA, 0x00,
-		uint32(len(args.Key)+0x14), 0x00, args.CAS)
-	// ext data
-	req.Write(extData.Bytes())
-	// key
-	req.WriteString(args.Key)
+	writeAtomicReq(req, opCode, args, 0x00)
 
 	body, extLen, cas, err := cmder.wait4Rsp(req)
 	defer func() {
@@ -269,6 +468,18 @@ func (cmder *Commander) atomic(opCode uint8, args *KeyArgs) (uint64, uint64, err
 	return atomicValue, cas, err
 }
 
+func writeAtomicReq(req *bytebufferpool.ByteBuffer, opCode uint8, args *KeyArgs, opaque uint32) {
+	// request header
+	writeReqHeader(req, MAGIC_REQUEST, opCode, uint16(len(args.Key)), 0x14, RAW_DATA, 0x00,
+		uint32(len(args.Key)+0x14), opaque, args.CAS)
+	// extra:20byte |----delta:8----|----initial:8----|----expiration:4----|
+	WriteUint64(req, args.Delta)
+	WriteUint64(req, args.Initial)
+	WriteUint32(req, args.Expiration)
+	// key
+	req.WriteString(args.Key)
+}
+
 func (cmder *Commander) touchAtomicValue(key string) (uint64, error) {
 	req := bytebufferpool.Get()
 	defer bytebufferpool.Put(req)
@@ -303,15 +514,11 @@ func (cmder *Commander) flush(args *KeyArgs) error {
 	req := bytebufferpool.Get()
 	defer bytebufferpool.Put(req)
 
-	extData := bytebufferpool.Get()
-	defer bytebufferpool.Put(req)
-	WriteUint32(extData, args.Expiration)
-
-	// header
+	// header, flush has no key and its body is the 4 bytes extra
 	writeReqHeader(req, MAGIC_REQUEST, OPCODE_FLUSH, 0x00, 0x04, RAW_DATA, 0x00,
 		0x04, 0x00, 0x00)
-	// ext data
-	req.Write(extData.Bytes())
+	// extra:4byte |----expiration:4----|
+	WriteUint32(req, args.Expiration)
 
 	body, _, _, err := cmder.wait4Rsp(req)
 	defer func() {
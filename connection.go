@@ -8,15 +8,39 @@ import (
 )
 
 func (cmder *Commander) Giveup() {
-	if cmder.giveup {
+	if !cmder.discard() {
 		return
 	}
 
+	cl := cmder.server.cluster
+	select {
+	case cl.badServerNoticer <- cmder.server:
+	case <-cl.ctx.Done():
+	}
+}
+
+// discard closes the connection of commander and records it as bad without noticing the server checker,
+// it returns false when there is nothing to check.
+func (cmder *Commander) discard() bool {
+	if cmder.giveup {
+		return false
+	}
+
+	// pipelined connection is shared, it is closed by its own goroutines when broken
+	if cmder.pipe != nil {
+		cmder.giveup = true
+		return false
+	}
+
 	cmder.conn.Close()
 	cmder.giveup = true
+	cl := cmder.server.cluster
+	cl.Lock()
 	cmder.server.badCmders = append(cmder.server.badCmders, cmder)
-	cmder.server.cluster.badServerNoticer <- cmder.server
+	cl.Unlock()
+	return true
 }
+
 func (cmder *Commander) flush2Server() error {
 	cmder.conn.SetWriteDeadline(time.Now().Add(WriterTimeout))
 	return cmder.rw.Flush()
@@ -27,7 +51,11 @@ func (cmder *Commander) readN(buffer *bytebufferpool.ByteBuffer, count int) (int
 		return 0, ErrInvalidArguments
 	}
 
-	cmder.conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+	// reader of pipelined connection waits for responses as long as connection lives,
+	// every pipelined request has its own timeout
+	if cmder.pipe == nil {
+		cmder.conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+	}
 	start := len(buffer.B)
 	max := cap(buffer.B)
 	if max == 0 {
@@ -44,6 +72,8 @@ func (cmder *Commander) readN(buffer *bytebufferpool.ByteBuffer, count int) (int
 	}
 
 	n, err := io.ReadFull(cmder.rw, buffer.B[start:start+count])
+	// never expose the stale bytes of a pooled buffer
+	buffer.B = buffer.B[:start+n]
 	return n, err
 }
 
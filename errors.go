@@ -1,6 +1,13 @@
 package gomemcached
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+)
 
 var (
 	ErrInvalidArguments        = errors.New("Invalid arguments")
@@ -11,6 +18,20 @@ var (
 	ErrNoUsableConnection      = errors.New("No usable connection")
 	ErrBadConnection           = errors.New("Bad connection")
 	ErrServerAlreadyInCluster  = errors.New("Server already in Cluster")
+	ErrLoadTimeout             = errors.New("Wait for loading value timeout")
+	ErrUpdateAbort             = errors.New("Update aborted")
+	ErrUpdateDelete            = errors.New("Update deletes key")
+	ErrUpdateConflict          = errors.New("Update conflicts too many times")
+	ErrLockHeld                = errors.New("Lock is held by another owner")
+	ErrLockNotHeld             = errors.New("Lock is not held")
+	ErrCircuitOpen             = errors.New("Circuit breaker is open")
+	ErrClientClosed            = errors.New("Client is closed")
+	ErrInvalidKey              = errors.New("Invalid key")
+	ErrServerUnavailable       = errors.New("Server is unavailable")
+	// classes of OpError, they are matched by errors.Is
+	ErrTimeout  = errors.New("Timeout")
+	ErrNetwork  = errors.New("Network error")
+	ErrProtocol = errors.New("Protocol error")
 	// memcached status
 	ErrKeyNotFound             = NewStatusError(errors.New("Key not found"))
 	ErrKeyExists               = NewStatusError(errors.New("Key exists"))
@@ -24,7 +45,7 @@ var (
 	ErrOutOfMemory             = NewStatusError(errors.New("Out of memory"))
 	ErrNotSupported            = NewStatusError(errors.New("Not supported"))
 	ErrInternalError           = NewStatusError(errors.New("Internal error"))
-	ErrBusy                    = NewStatusError(errors.New("Internal error"))
+	ErrBusy                    = NewStatusError(errors.New("Server busy"))
 	ErrTemporaryFailure        = NewStatusError(errors.New("Temporary failure"))
 	ErrUnmarshalFailed         = NewStatusError(errors.New("Unmarshal value failed"))
 	ErrMarshalFailed           = NewStatusError(errors.New("Marshal value failed"))
@@ -40,19 +61,159 @@ func (s *StatusError) Error() string {
 	return s.Err.Error()
 }
 
-func (s *StatusError) Is(err error) bool {
-	e, ok := err.(*StatusError)
+// Is reports whether target is a StatusError of the same status.
+func (s *StatusError) Is(target error) bool {
+	e, ok := target.(*StatusError)
 	if !ok {
 		return false
 	}
 
-	return e == s.Err
+	return e.Err == s.Err
 }
 
 func (s *StatusError) Unwrap() error {
 	return s.Err
 }
 
+// OpError is the error of an operation on server,
+// it matches the sentinel errors it wraps and ErrTimeout/ErrNetwork/ErrProtocol by errors.Is.
+type OpError struct {
+	// Name of operation, such as "get".
+	Op   string
+	Key  string
+	Addr string
+	// Status of response, 0 if server did not answer a status error.
+	Status uint16
+	// Error message of server in the body of response.
+	ServerMsg string
+	Err       error
+}
+
+func (e *OpError) Error() string {
+	var b strings.Builder
+	b.WriteString("memcached")
+	if e.Op != "" {
+		b.WriteString(" " + e.Op)
+	}
+	if e.Key != "" {
+		fmt.Fprintf(&b, " %q", e.Key)
+	}
+	if e.Addr != "" {
+		b.WriteString(" on " + e.Addr)
+	}
+	b.WriteString(": " + e.Err.Error())
+	if e.Status != 0 {
+		fmt.Fprintf(&b, " (status 0x%04x", e.Status)
+		if e.ServerMsg != "" {
+			fmt.Fprintf(&b, ": %q", e.ServerMsg)
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of class target(ErrTimeout, ErrNetwork or ErrProtocol),
+// the wrapped errors are matched by errors.Is through Unwrap.
+func (e *OpError) Is(target error) bool {
+	switch target {
+	case ErrTimeout, ErrNetwork, ErrProtocol:
+		return errorClass(e.Err) == target
+	}
+	return false
+}
+
+// Timeout reports whether the operation timed out.
+func (e *OpError) Timeout() bool {
+	return errorClass(e.Err) == ErrTimeout
+}
+
+// errorClass returns ErrTimeout, ErrNetwork or ErrProtocol for the errors of connection,
+// nil for the other errors.
+func errorClass(err error) error {
+	if errors.Is(err, ErrProtocol) {
+		return ErrProtocol
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrTimeout
+		}
+		return ErrNetwork
+	}
+
+	for _, target := range []error{io.EOF, io.ErrUnexpectedEOF, ErrBadConnection, ErrConnError, ErrNotConnected} {
+		if errors.Is(err, target) {
+			return ErrNetwork
+		}
+	}
+
+	return nil
+}
+
+// newOpError wraps err of operation on server `addr`,
+// the fields of an OpError are only filled when they are empty.
+func newOpError(opCode uint8, key string, addr string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	opErr, ok := err.(*OpError)
+	if !ok {
+		return &OpError{Op: opName(opCode), Key: key, Addr: addr, Err: err}
+	}
+
+	e := *opErr
+	if e.Op == "" {
+		e.Op = opName(opCode)
+	}
+	if e.Key == "" {
+		e.Key = key
+	}
+	if e.Addr == "" {
+		e.Addr = addr
+	}
+	return &e
+}
+
+// isStatusError reports whether err is a memcached status, the connection is still usable after it.
+func isStatusError(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr)
+}
+
+// ServerErrors is the errors of an operation on many servers keyed by server address.
+type ServerErrors map[string]error
+
+func (e ServerErrors) Error() string {
+	addrs := make([]string, 0, len(e))
+	for addr := range e {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	msgs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		msgs[i] = addr + ": " + e[addr].Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any error of servers matches target.
+func (e ServerErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func NewStatusError(err error) *StatusError {
 	return &StatusError{Err: err}
 }
@@ -0,0 +1,64 @@
+package gomemcached
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestOpError(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	var value string
+	_, err := m.Get("TestOpError", &value)
+	var opErr *OpError
+	if !errors.As(err, &opErr) {
+		t.Fatalf("Get err %T: %v", err, err)
+	}
+	if opErr.Op != "get" || opErr.Key != "TestOpError" || opErr.Addr != servers[0].Addr() ||
+		opErr.Status != STATUS_KEY_NOT_FOUND || opErr.ServerMsg == "" {
+		t.Errorf("OpError: %+v", opErr)
+	}
+	if !errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrKeyExists) {
+		t.Errorf("%v does not match its status", err)
+	}
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrNetwork) || errors.Is(err, ErrProtocol) {
+		t.Errorf("status error %v is classified as connection error", err)
+	}
+
+	readTimeout := ReadTimeout
+	ReadTimeout = time.Millisecond * 50
+	defer func() { ReadTimeout = readTimeout }()
+
+	servers[0].SetDelay(time.Millisecond * 200)
+	_, err = m.Get("TestOpError", &value)
+	servers[0].SetDelay(0)
+	if !errors.Is(err, ErrTimeout) || !errors.As(err, &opErr) || !opErr.Timeout() {
+		t.Errorf("Get err is not timeout: %v", err)
+	}
+
+	servers[0].Close()
+	_, err = m.Get("TestOpError", &value)
+	if !errors.Is(err, ErrNetwork) && !errors.Is(err, ErrNoUsableConnection) {
+		t.Errorf("Get err is not network error: %v", err)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	protocolErr := &OpError{Op: "getq", Err: fmt.Errorf("%w: bad magic", ErrProtocol)}
+	if !errors.Is(protocolErr, ErrProtocol) || errors.Is(protocolErr, ErrNetwork) {
+		t.Errorf("protocol error: %v", protocolErr)
+	}
+
+	if !errors.Is(NewStatusError(ErrBusy.Err), ErrBusy) || errors.Is(ErrBusy, ErrInternalError) {
+		t.Errorf("StatusError.Is compares wrong errors")
+	}
+	if ErrBusy.Error() == ErrInternalError.Error() {
+		t.Errorf("ErrBusy says %v", ErrBusy)
+	}
+}
@@ -0,0 +1,68 @@
+package gomemcached
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// MaxRelativeExpiration is the max expiration in seconds which memcached takes as relative to now,
+// the larger expirations are taken as Unix time.
+const MaxRelativeExpiration = 60 * 60 * 24 * 30
+
+// resolveExpiration converts TTL or ExpiresAt of args to Expiration in the encoding of memcached,
+// `jitter` extends TTL by a random duration up to `jitter` times of it.
+// Only one of Expiration, TTL and ExpiresAt can be set.
+func resolveExpiration(args *KeyArgs, jitter float64) error {
+	set := 0
+	if args.Expiration != 0 {
+		set++
+	}
+	if args.TTL != 0 {
+		set++
+	}
+	if !args.ExpiresAt.IsZero() {
+		set++
+	}
+	if set > 1 {
+		return fmt.Errorf("%w: only one of Expiration, TTL and ExpiresAt can be set", ErrInvalidArguments)
+	}
+
+	now := time.Now()
+	switch {
+	case args.TTL < 0:
+		return fmt.Errorf("%w: negative TTL %v", ErrInvalidArguments, args.TTL)
+	case args.TTL > 0:
+		ttl := args.TTL
+		if jitter > 0 {
+			ttl += time.Duration(rand.Int63n(int64(float64(ttl)*jitter) + 1))
+		}
+		args.Expiration = ttlExpiration(ttl, now)
+	case !args.ExpiresAt.IsZero():
+		unix := args.ExpiresAt.Unix()
+		if unix <= MaxRelativeExpiration || unix > math.MaxUint32 {
+			return fmt.Errorf("%w: ExpiresAt %v is out of range", ErrInvalidArguments, args.ExpiresAt)
+		}
+		args.Expiration = uint32(unix)
+	}
+
+	args.TTL = 0
+	args.ExpiresAt = time.Time{}
+	return nil
+}
+
+// ttlExpiration rounds ttl up to seconds,
+// the ttl longer than MaxRelativeExpiration is converted to Unix time.
+func ttlExpiration(ttl time.Duration, now time.Time) uint32 {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds <= MaxRelativeExpiration {
+		return uint32(seconds)
+	}
+
+	return uint32(now.Unix() + seconds)
+}
+
+func (m *MemcachedClient) SetTTLJitter(jitter float64) {
+	m.ttlJitter = jitter
+}
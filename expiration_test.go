@@ -0,0 +1,94 @@
+package gomemcached
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestResolveExpiration(t *testing.T) {
+	now := time.Now()
+	expiresAt := now.Add(time.Hour * 24 * 60)
+	cases := []struct {
+		args KeyArgs
+		want uint32
+	}{
+		{KeyArgs{}, 0},
+		{KeyArgs{Expiration: 100}, 100},
+		{KeyArgs{TTL: time.Millisecond * 1500}, 2},
+		{KeyArgs{TTL: time.Hour * 24 * 30}, MaxRelativeExpiration},
+		{KeyArgs{ExpiresAt: expiresAt}, uint32(expiresAt.Unix())},
+	}
+
+	for _, c := range cases {
+		args := c.args
+		if err := resolveExpiration(&args, 0); err != nil || args.Expiration != c.want {
+			t.Errorf("%+v: %v, %v, want %v", c.args, args.Expiration, err, c.want)
+		}
+	}
+
+	// longer than 30 days is converted to Unix time
+	ttl := time.Hour * 24 * 31
+	args := KeyArgs{TTL: ttl}
+	if err := resolveExpiration(&args, 0); err != nil {
+		t.Fatalf("resolveExpiration err: %v", err)
+	}
+	want := now.Add(ttl).Unix()
+	if got := int64(args.Expiration); got < want || got > want+2 {
+		t.Errorf("31 days: %v, want %v", got, want)
+	}
+
+	for _, args := range []KeyArgs{
+		{Expiration: 100, TTL: time.Second},
+		{TTL: -time.Second},
+		{ExpiresAt: time.Unix(100, 0)},
+	} {
+		if err := resolveExpiration(&args, 0); !errors.Is(err, ErrInvalidArguments) {
+			t.Errorf("%+v: %v", args, err)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		args := KeyArgs{TTL: time.Second * 100}
+		resolveExpiration(&args, 0.5)
+		if args.Expiration < 100 || args.Expiration > 150 {
+			t.Fatalf("jittered expiration %v", args.Expiration)
+		}
+	}
+}
+
+func TestTTL(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	ttl := time.Hour * 24 * 31
+	if _, err := m.Set(&KeyArgs{Key: "TestTTL", Value: "HelloWorld", TTL: ttl}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+	var value string
+	if _, err := m.Get("TestTTL", &value); err != nil {
+		t.Fatalf("Get err: %v", err)
+	}
+	if exp, _ := servers[0].Expiration("TestTTL"); exp.Sub(time.Now().Add(ttl)) > time.Second*2 || time.Now().Add(ttl).Sub(exp) > time.Second*2 {
+		t.Errorf("expiration %v, want %v", exp, time.Now().Add(ttl))
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	if _, err := m.Touch(&KeyArgs{Key: "TestTTL", ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("Touch err: %v", err)
+	}
+	if exp, _ := servers[0].Expiration("TestTTL"); !exp.Equal(expiresAt) {
+		t.Errorf("expiration %v, want %v", exp, expiresAt)
+	}
+
+	results := m.NewBatch().Set(&KeyArgs{Key: "TestTTLBatch", Value: 1, TTL: ttl}).Execute()
+	if results[0].Err != nil {
+		t.Fatalf("batch Set err: %v", results[0].Err)
+	}
+	if exp, ok := servers[0].Expiration("TestTTLBatch"); !ok || exp.Before(time.Now().Add(ttl-time.Minute)) {
+		t.Errorf("batch expiration %v, %v", exp, ok)
+	}
+}
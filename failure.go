@@ -0,0 +1,115 @@
+package gomemcached
+
+import (
+	"sort"
+	"time"
+)
+
+// FailurePolicy decides where the keys of a dead server go, a server is dead when all of its connections are broken.
+type FailurePolicy int
+
+const (
+	// Remove the dead server from ring, its keys move to the next servers on ring.
+	// The copies written to them meanwhile are stale once the server comes back.
+	FailureRehash FailurePolicy = iota
+	// Keep the dead server on ring, the operations of its keys fail with ErrServerUnavailable until it recovers.
+	FailureFailFast
+	// Same as FailureFailFast except that Gets of its keys are read from the next server on ring.
+	FailureReadOnly
+)
+
+func (m *MemcachedClient) SetFailurePolicy(policy FailurePolicy, grace time.Duration) {
+	m.cluster.setFailurePolicy(policy, grace)
+}
+
+func (cl *Cluster) setFailurePolicy(policy FailurePolicy, grace time.Duration) {
+	cl.Lock()
+	defer cl.Unlock()
+
+	cl.failurePolicy = policy
+	cl.deadGrace = grace
+}
+
+func (cl *Cluster) getFailurePolicy() FailurePolicy {
+	cl.RLock()
+	defer cl.RUnlock()
+
+	return cl.failurePolicy
+}
+
+func isRead(opCode uint8) bool {
+	switch opCode {
+	case OPCODE_GET, OPCODE_GETK, OPCODE_GETQ:
+		return true
+	}
+	return false
+}
+
+func (cl *Cluster) markServerDead(s *Server) {
+	// server was already removed, maybe replaced by a new server with same address
+	if cl.addr2Servers[s.Addr] != s {
+		return
+	}
+
+	s.dead = true
+	s.deadSince = time.Now()
+	// the idle connections are broken, connections are dialed again when the server recovers
+	s.closeCmders()
+}
+
+// doCheckDeadServers reconnects the dead servers, a server which accepts connections again is back on service.
+// Under FailureRehash the server which is still dead after the grace window is removed from ring,
+// except the joining server, which is put on ring once it accepts connections.
+func (cl *Cluster) doCheckDeadServers() {
+	var dead []*Server
+	cl.RLock()
+	for _, s := range cl.addr2Servers {
+		if s.dead {
+			dead = append(dead, s)
+		}
+	}
+	cl.RUnlock()
+
+	for _, s := range dead {
+		// dialing may last ConnectTimeout, the cluster is not locked meanwhile
+		cmders := s.dialCmders()
+
+		cl.Lock()
+		switch {
+		case cl.closed || cl.addr2Servers[s.Addr] != s || !s.dead:
+			for _, cmder := range cmders {
+				cmder.conn.Close()
+			}
+		case len(cmders) > 0:
+			s.dead = false
+			s.unreachable = false
+			s.badCmders = nil
+			for _, cmder := range cmders {
+				s.cmders[cmder.ID] = cmder
+			}
+			if s.joining {
+				s.joining = false
+				cl.ringServer(s)
+				sort.Sort(SortList(cl.nodeList))
+			}
+		case cl.failurePolicy == FailureRehash && !s.joining && time.Since(s.deadSince) >= cl.deadGrace:
+			cl.cleanBadServer(s)
+			cl.rebuildNodeList()
+		}
+		cl.Unlock()
+	}
+}
+
+// dialCmders connects MaxCommanderCount connections to server, it stops at the first failure.
+func (s *Server) dialCmders() []*Commander {
+	var cmders []*Commander
+	for i := 0; i < int(s.MaxCommanderCount); i++ {
+		conn, err := connect(s.Addr)
+		if err != nil {
+			break
+		}
+		cmders = append(cmders, s.newCmder(conn))
+	}
+
+	return cmders
+}
@@ -0,0 +1,126 @@
+package gomemcached
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shaoyuan1943/gomemcached/internal/fakeserver"
+)
+
+// waitFor polls cond until it is true or one second elapses.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fastHeartbeat shortens HeartbeatInterval, the returned function restores it after the client exits.
+func fastHeartbeat() func() {
+	interval := HeartbeatInterval
+	HeartbeatInterval = 20 * time.Millisecond
+	return func() { HeartbeatInterval = interval }
+}
+
+// startFailureClient starts a client of servers and returns the primary server of key.
+func startFailureClient(servers []*fakeserver.Server, key string) (*MemcachedClient, *fakeserver.Server) {
+	m := NewMemcachedClient(fakeServerAddrs(servers), 1).(*MemcachedClient)
+	primary := m.cluster.ChooseServersByKey(key, 1)[0]
+	return m, fakeServerByAddr(servers, primary.Addr)
+}
+
+func killServer(t *testing.T, m *MemcachedClient, s *fakeserver.Server, key string) {
+	s.Close()
+	// the broken connection is found by an operation
+	var value string
+	m.Get(key, &value)
+	waitFor(t, "server dead", func() bool {
+		_, err := m.Set(&KeyArgs{Key: key, Value: "HelloWorld"})
+		return errors.Is(err, ErrServerUnavailable)
+	})
+}
+
+func TestFailureFailFast(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+	defer fastHeartbeat()()
+
+	m, primary := startFailureClient(servers, "TestFailure")
+	defer m.Exit()
+	m.SetFailurePolicy(FailureFailFast, 0)
+
+	addr := primary.Addr()
+	killServer(t, m, primary, "TestFailure")
+
+	var value string
+	_, err := m.Get("TestFailure", &value)
+	if !errors.Is(err, ErrServerUnavailable) {
+		t.Fatalf("Get of dead server err: %v", err)
+	}
+	if s := m.cluster.ChooseServersByKey("TestFailure", 1)[0]; s.Addr != addr {
+		t.Fatalf("key moved to %v", s.Addr)
+	}
+
+	restarted, err := fakeserver.StartAt(addr)
+	if err != nil {
+		t.Fatalf("restart fake server err: %v", err)
+	}
+	defer restarted.Close()
+
+	waitFor(t, "server recovered", func() bool {
+		_, err := m.Set(&KeyArgs{Key: "TestFailure", Value: "HelloWorld"})
+		return err == nil
+	})
+	if _, _, ok := restarted.Value("TestFailure"); !ok {
+		t.Fatalf("key not written to recovered server")
+	}
+}
+
+func TestFailureReadOnly(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+	defer fastHeartbeat()()
+
+	m, primary := startFailureClient(servers, "TestFailure")
+	defer m.Exit()
+	m.SetFailurePolicy(FailureReadOnly, 0)
+
+	killServer(t, m, primary, "TestFailure")
+
+	// the read goes to the next server which does not hold the key
+	var value string
+	_, err := m.Get("TestFailure", &value)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Get of dead server err: %v", err)
+	}
+
+	err = m.Delete(&KeyArgs{Key: "TestFailure"})
+	if !errors.Is(err, ErrServerUnavailable) {
+		t.Fatalf("Delete of dead server err: %v", err)
+	}
+}
+
+func TestFailureRehashGrace(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+	defer fastHeartbeat()()
+
+	m, primary := startFailureClient(servers, "TestFailure")
+	defer m.Exit()
+	m.SetFailurePolicy(FailureRehash, 200*time.Millisecond)
+
+	addr := primary.Addr()
+	killServer(t, m, primary, "TestFailure")
+
+	waitFor(t, "server removed", func() bool {
+		_, err := m.Set(&KeyArgs{Key: "TestFailure", Value: "HelloWorld"})
+		return err == nil
+	})
+	if s := m.cluster.ChooseServersByKey("TestFailure", 1)[0]; s.Addr == addr {
+		t.Fatalf("dead server is still on ring")
+	}
+}
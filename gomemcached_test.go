@@ -2,6 +2,8 @@ package gomemcached
 
 import (
 	"math/rand"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -166,7 +168,7 @@ func TestAtomic(t *testing.T) {
 
 	val2, err := Instance().TouchAtomicValue("TestAtomic_incr")
 	if err != nil {
-		if _, ok := err.(*StatusError); !ok {
+		if !isStatusError(err) {
 			t.Errorf("TestAtomic_incr_touch err: %v", err)
 		} else {
 			t.Logf("TestAtomic_incr_touch err: %v", err)
@@ -352,3 +354,39 @@ func BenchmarkMemcachedClient(b *testing.B) {
 		}
 	}
 }
+
+func TestFlushServers(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	for i := 0; i < 30; i++ {
+		if _, err := m.Set(&KeyArgs{Key: "TestFlushServers" + strconv.Itoa(i), Value: i}); err != nil {
+			t.Fatalf("Set err: %v", err)
+		}
+	}
+
+	if err := m.FlushServer(servers[0].Addr()); err != nil {
+		t.Fatalf("FlushServer err: %v", err)
+	}
+	if servers[0].Len() != 0 || servers[1].Len() == 0 || servers[2].Len() == 0 {
+		t.Errorf("items after FlushServer: %v, %v, %v", servers[0].Len(), servers[1].Len(), servers[2].Len())
+	}
+
+	// the failed server does not stop the others
+	failed := servers[1].Addr()
+	servers[1].Close()
+	err := m.Flush(&KeyArgs{})
+	errs, ok := err.(ServerErrors)
+	if !ok || len(errs) != 1 || errs[failed] == nil {
+		t.Fatalf("Flush err: %v", err)
+	}
+	if !strings.Contains(err.Error(), failed) {
+		t.Errorf("error does not name %v: %v", failed, err)
+	}
+	if servers[2].Len() != 0 {
+		t.Errorf("%v items are not flushed", servers[2].Len())
+	}
+}
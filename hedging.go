@@ -0,0 +1,249 @@
+package gomemcached
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of the latest latencies of primary servers which the percentile of hedging is computed from.
+const hedgeWindow = 1024
+
+// errHedgeCancelled fails the slower request of hedging, it never reaches caller.
+var errHedgeCancelled = errors.New("Hedged request cancelled")
+
+// HedgingPolicy sends a Get also to the next server on ring(the replica when replication is enabled)
+// when the primary server has not answered after a delay, the first successful answer wins.
+type HedgingPolicy struct {
+	// Delay before the hedged request is sent.
+	Delay time.Duration
+	// Use the latency percentile of primary servers as delay, such as 0.95, 0 disables it.
+	// Delay is used until enough latencies are observed.
+	Percentile float64
+}
+
+type hedger struct {
+	policy HedgingPolicy
+	mu     sync.Mutex
+	// ring buffer of latencies
+	latencies []time.Duration
+	next      int
+	// cached percentile, it is computed again after every `hedgeWindow/16` observations
+	delay    time.Duration
+	observed int
+}
+
+func newHedger(policy HedgingPolicy) *hedger {
+	return &hedger{
+		policy: policy,
+		delay:  policy.Delay,
+	}
+}
+
+func (h *hedger) observe(latency time.Duration) {
+	if h.policy.Percentile <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < hedgeWindow {
+		h.latencies = append(h.latencies, latency)
+	} else {
+		h.latencies[h.next] = latency
+		h.next = (h.next + 1) % hedgeWindow
+	}
+
+	h.observed++
+	if h.observed < hedgeWindow/16 {
+		return
+	}
+	h.observed = 0
+
+	sorted := make([]time.Duration, len(h.latencies))
+	copy(sorted, h.latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(float64(len(sorted)) * h.policy.Percentile)
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	h.delay = sorted[i]
+}
+
+func (h *hedger) hedgeDelay() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.delay
+}
+
+func (m *MemcachedClient) SetHedging(policy *HedgingPolicy) {
+	if policy == nil {
+		m.hedging = nil
+		return
+	}
+	m.hedging = newHedger(*policy)
+}
+
+type rawResult struct {
+	flag uint32
+	data []byte
+	cas  uint64
+	err  error
+}
+
+// fetchHedged reads key from primary server, and from the next server on ring when primary server
+// has not answered after the delay of hedging or failed with replication enabled.
+// The slower request is cancelled once the other one succeeds.
+// Without replication the answer of primary server always wins, the next server only serves when it fails.
+func (m *MemcachedClient) fetchHedged(key string, h *hedger, cancel <-chan struct{}) (uint32, []byte, uint64, error) {
+	servers := m.cluster.ChooseServersByKey(key, 2)
+	// without replication the next server only holds the copies written while the ring was rehashed,
+	// the other failure policies keep keys away from it
+	if len(servers) < 2 || (m.replicas <= 1 && m.cluster.getFailurePolicy() != FailureRehash) {
+		res := m.fetchRawFrom(key, nil, cancel, context.Canceled)
+		return res.flag, res.data, res.cas, res.err
+	}
+
+	cancelPrimary := make(chan struct{})
+	cancelHedged := make(chan struct{})
+	defer close(cancelPrimary)
+	defer close(cancelHedged)
+
+	primary := make(chan rawResult, 1)
+	start := time.Now()
+	go func() {
+		res := m.fetchRawFrom(key, nil, cancelPrimary, errHedgeCancelled)
+		if res.err == nil || isStatusError(res.err) {
+			h.observe(time.Since(start))
+		}
+		primary <- res
+	}()
+
+	timer := time.NewTimer(h.hedgeDelay())
+	defer timer.Stop()
+
+	var primaryRes *rawResult
+	select {
+	case res := <-primary:
+		// replica is read on failure of primary server the same as execReplicasRead
+		if res.err == nil || m.replicas <= 1 {
+			return res.flag, res.data, res.cas, res.err
+		}
+		primaryRes = &res
+	case <-timer.C:
+	case <-cancel:
+		// both requests are cancelled by the deferred closes
+		return 0, nil, 0, context.Canceled
+	}
+
+	hedged := make(chan rawResult, 1)
+	go func() {
+		hedged <- m.fetchRawFrom(key, servers[1], cancelHedged, errHedgeCancelled)
+	}()
+
+	var hedgedRes *rawResult
+	for primaryRes == nil || hedgedRes == nil {
+		select {
+		case res := <-primary:
+			primaryRes = &res
+		case res := <-hedged:
+			hedgedRes = &res
+		case <-cancel:
+			return 0, nil, 0, context.Canceled
+		}
+
+		// without replication the next server only holds stale copies, any answer of primary server is final
+		// even a miss, the next server is only read when primary server fails to answer
+		if primaryRes != nil && (primaryRes.err == nil || (m.replicas <= 1 && isStatusError(primaryRes.err))) {
+			return primaryRes.flag, primaryRes.data, primaryRes.cas, primaryRes.err
+		}
+		if hedgedRes != nil && hedgedRes.err == nil && (m.replicas > 1 || primaryRes != nil) {
+			return hedgedRes.flag, hedgedRes.data, hedgedRes.cas, nil
+		}
+	}
+
+	// the error of primary server is returned when both failed
+	return primaryRes.flag, primaryRes.data, primaryRes.cas, primaryRes.err
+}
+
+// fetchRawFrom reads the raw value of key from server `s`, or the server chosen by key if `s` is nil,
+// the request fails with cancelErr when cancel is closed.
+func (m *MemcachedClient) fetchRawFrom(key string, s *Server, cancel <-chan struct{}, cancelErr error) rawResult {
+	var res rawResult
+	cmdFunc := cancellable(cancel, cancelErr, func(cmder *Commander) error {
+		var err error
+		res.flag, res.data, res.cas, err = cmder.getRaw(key)
+		return err
+	})
+
+	if s == nil {
+		res.err = m.exec(OPCODE_GET, key, cmdFunc)
+	} else {
+		res.err = m.execServer(OPCODE_GET, key, s.Addr, cmdFunc)
+	}
+	return res
+}
+
+// cancellable wraps cmdFunc, its request is interrupted and fails with cancelErr once cancel is closed.
+func cancellable(cancel <-chan struct{}, cancelErr error, cmdFunc func(cmder *Commander) error) func(cmder *Commander) error {
+	if cancel == nil {
+		return cmdFunc
+	}
+
+	return func(cmder *Commander) error {
+		stop := cmder.cancelBy(cancel)
+		err := cmdFunc(cmder)
+		if stop() && err != nil && !isStatusError(err) {
+			// the connection is renewed without taking server as bad
+			return &callerError{cancelErr}
+		}
+		return err
+	}
+}
+
+// cancelBy interrupts the request of commander once cancel is closed,
+// the pending request of a pipelined connection is dropped and the read of a pooled connection fails at once.
+// The returned function stops watching and reports whether cancel was closed.
+func (cmder *Commander) cancelBy(cancel <-chan struct{}) func() bool {
+	if cancel == nil {
+		return func() bool { return false }
+	}
+
+	if cmder.pipe != nil {
+		cmder.cancel = cancel
+		return func() bool {
+			select {
+			case <-cancel:
+				return true
+			default:
+				return false
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	interrupted := false
+	go func() {
+		defer close(exited)
+		select {
+		case <-cancel:
+			interrupted = true
+			cmder.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		<-exited
+		if interrupted {
+			// the next request on connection must not see the past deadline
+			cmder.conn.SetDeadline(time.Time{})
+		}
+		return interrupted
+	}
+}
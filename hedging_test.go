@@ -0,0 +1,148 @@
+package gomemcached
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHedging(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetReplication(2, WriteAckAll, PartialWriteKeep)
+	m.SetHedging(&HedgingPolicy{Delay: time.Millisecond * 20})
+
+	key := "TestHedging"
+	if _, err := m.Set(&KeyArgs{Key: key, Value: "HelloWorld"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	primary := fakeServerByAddr(servers, m.cluster.ChooseServersByKey(key, 1)[0].Addr)
+	conns := primary.ConnCount()
+	primary.SetDelay(time.Millisecond * 300)
+
+	var value string
+	start := time.Now()
+	if _, err := m.Get(key, &value); err != nil || value != "HelloWorld" {
+		t.Fatalf("hedged Get: %v, %v", value, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*200 {
+		t.Errorf("hedged Get took %v", elapsed)
+	}
+
+	// the slower request is cancelled and its connection is renewed
+	time.Sleep(time.Millisecond * 400)
+	primary.SetDelay(0)
+	value = ""
+	if _, err := m.Get(key, &value); err != nil || value != "HelloWorld" {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+	if n := primary.ConnCount(); n != conns {
+		t.Errorf("primary server has %v connections, want %v", n, conns)
+	}
+}
+
+func TestHedgingCancel(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 1).(*MemcachedClient)
+	defer m.Exit()
+	m.SetReplication(2, WriteAckAll, PartialWriteKeep)
+	m.SetHedging(&HedgingPolicy{Delay: time.Millisecond * 20})
+
+	key := "TestHedgingCancel"
+	if _, err := m.Set(&KeyArgs{Key: key, Value: "HelloWorld"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	primary := m.cluster.ChooseServersByKey(key, 1)[0]
+	fakeServerByAddr(servers, primary.Addr).SetDelay(time.Millisecond * 1500)
+
+	var value string
+	if _, err := m.Get(key, &value); err != nil || value != "HelloWorld" {
+		t.Fatalf("hedged Get: %v, %v", value, err)
+	}
+	fakeServerByAddr(servers, primary.Addr).SetDelay(0)
+
+	// the only connection of primary server is back to pool long before the slow response
+	waitFor(t, "connection of cancelled request", func() bool {
+		err := m.execServer(OPCODE_NOOP, "", primary.Addr, func(cmder *Commander) error {
+			return cmder.noop()
+		})
+		return err == nil
+	})
+
+	m.cluster.RLock()
+	bad := primary.dead || len(primary.badCmders) > 0
+	m.cluster.RUnlock()
+	if bad {
+		t.Errorf("cancelled request takes server as bad")
+	}
+}
+
+func TestHedgingPrimaryMiss(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetHedging(&HedgingPolicy{Delay: time.Millisecond * 20})
+
+	// the next server holds a stale copy left by rehashing
+	key := "TestHedgingPrimaryMiss"
+	ring := m.cluster.ChooseServersByKey(key, 2)
+	next := NewMemcachedClient([]string{ring[1].Addr}, 1)
+	defer next.Exit()
+	if _, err := next.Set(&KeyArgs{Key: key, Value: "stale"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	fakeServerByAddr(servers, ring[0].Addr).SetDelay(time.Millisecond * 100)
+
+	var value string
+	if _, err := m.Get(key, &value); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Get of primary miss: %v, %v", value, err)
+	}
+}
+
+func TestHedgingFailFast(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetFailurePolicy(FailureFailFast, 0)
+	m.SetHedging(&HedgingPolicy{Delay: time.Millisecond * 20})
+
+	key := "TestHedgingFailFast"
+	if _, err := m.Set(&KeyArgs{Key: key, Value: "HelloWorld"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	ring := m.cluster.ChooseServersByKey(key, 2)
+	fakeServerByAddr(servers, ring[0].Addr).SetDelay(time.Millisecond * 100)
+
+	// the next server without replication is never read under FailureFailFast
+	var value string
+	if _, err := m.Get(key, &value); err != nil || value != "HelloWorld" {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+	if n := fakeServerByAddr(servers, ring[1].Addr).OpCount(OPCODE_GET); n != 0 {
+		t.Errorf("next server is read %v times", n)
+	}
+}
+
+func TestHedgingPercentile(t *testing.T) {
+	h := newHedger(HedgingPolicy{Delay: time.Second, Percentile: 0.9})
+	for i := 1; i <= hedgeWindow; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	if delay := h.hedgeDelay(); delay < time.Millisecond*900 || delay > time.Millisecond*930 {
+		t.Errorf("delay of percentile: %v", delay)
+	}
+}
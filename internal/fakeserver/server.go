@@ -0,0 +1,590 @@
+// Package fakeserver implements a small in-memory memcached server speaking
+// the binary protocol. It is only meant for tests.
+package fakeserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	magicRequest  uint8 = 0x80
+	magicResponse uint8 = 0x81
+	headerLen           = 24
+	relativeLimit       = 60 * 60 * 24 * 30
+)
+
+const (
+	opGet      uint8 = 0x00
+	opSet      uint8 = 0x01
+	opAdd      uint8 = 0x02
+	opReplace  uint8 = 0x03
+	opDelete   uint8 = 0x04
+	opIncr     uint8 = 0x05
+	opDecr     uint8 = 0x06
+	opQuit     uint8 = 0x07
+	opFlush    uint8 = 0x08
+	opGetQ     uint8 = 0x09
+	opNoop     uint8 = 0x0a
+	opVersion  uint8 = 0x0b
+	opGetK     uint8 = 0x0c
+	opGetKQ    uint8 = 0x0d
+	opAppend   uint8 = 0x0e
+	opPrepend  uint8 = 0x0f
+	opSetQ     uint8 = 0x11
+	opAddQ     uint8 = 0x12
+	opReplaceQ uint8 = 0x13
+	opDeleteQ  uint8 = 0x14
+	opIncrQ    uint8 = 0x15
+	opDecrQ    uint8 = 0x16
+	opQuitQ    uint8 = 0x17
+	opFlushQ   uint8 = 0x18
+	opAppendQ  uint8 = 0x19
+	opPrependQ uint8 = 0x1a
+	opTouch    uint8 = 0x1c
+)
+
+const (
+	statusOK            uint16 = 0x0000
+	statusKeyNotFound   uint16 = 0x0001
+	statusKeyExists     uint16 = 0x0002
+	statusInvalidArgs   uint16 = 0x0004
+	statusNotStored     uint16 = 0x0005
+	statusNonNumeric    uint16 = 0x0006
+	statusUnknownCmd    uint16 = 0x0081
+	statusInternalError uint16 = 0x0084
+)
+
+var statusMessages = map[uint16]string{
+	statusKeyNotFound:   "Not found",
+	statusKeyExists:     "Data exists for key.",
+	statusInvalidArgs:   "Invalid arguments",
+	statusNotStored:     "Not stored.",
+	statusNonNumeric:    "Non-numeric server-side value for incr or decr",
+	statusUnknownCmd:    "Unknown command",
+	statusInternalError: "Internal error",
+}
+
+type item struct {
+	flags  uint32
+	value  []byte
+	cas    uint64
+	expire time.Time
+}
+
+func (it *item) expired(now time.Time) bool {
+	return !it.expire.IsZero() && !now.Before(it.expire)
+}
+
+type request struct {
+	opcode uint8
+	opaque uint32
+	cas    uint64
+	extras []byte
+	key    string
+	value  []byte
+}
+
+// Server is an in-memory memcached server listening on a random local port.
+type Server struct {
+	ln     net.Listener
+	mu     sync.Mutex
+	items  map[string]*item
+	casSeq uint64
+	conns  map[net.Conn]struct{}
+	ops    map[uint8]int
+	delay  time.Duration
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Start starts a new server on 127.0.0.1.
+func Start() (*Server, error) {
+	return StartAt("127.0.0.1:0")
+}
+
+// StartAt starts a new server on addr, such as the address of a closed server.
+func StartAt(addr string) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		ln:    ln,
+		items: make(map[string]*item),
+		conns: make(map[net.Conn]struct{}),
+		ops:   make(map[uint8]int),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the server and closes every client connection.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.ln.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// SetDelay makes the server wait before answering every request.
+func (s *Server) SetDelay(d time.Duration) {
+	s.mu.Lock()
+	s.delay = d
+	s.mu.Unlock()
+}
+
+// OpCount returns how many requests with the opcode have been received.
+func (s *Server) OpCount(opcode uint8) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ops[opcode]
+}
+
+// ConnCount returns the number of open client connections.
+func (s *Server) ConnCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Value returns the raw value stored under key.
+func (s *Server) Value(key string) ([]byte, uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.items[key]
+	if !ok || it.expired(time.Now()) {
+		return nil, 0, false
+	}
+
+	return append([]byte(nil), it.value...), it.flags, true
+}
+
+// Expiration returns the absolute expiration time of key, zero if it never expires.
+func (s *Server) Expiration(key string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.items[key]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return it.expire, true
+}
+
+// Len returns the number of live items.
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for _, it := range s.items {
+		if !it.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		req, err := readRequest(r)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.ops[req.opcode]++
+		delay := s.delay
+		s.mu.Unlock()
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+
+		quit := s.dispatch(w, req)
+		// only flush when no more pipelined requests are buffered
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+
+		if quit {
+			return
+		}
+	}
+}
+
+func readRequest(r *bufio.Reader) (*request, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != magicRequest {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(header[2:4]))
+	extLen := int(header[4])
+	bodyLen := int(binary.BigEndian.Uint32(header[8:12]))
+	if bodyLen < keyLen+extLen {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	return &request{
+		opcode: header[1],
+		opaque: binary.BigEndian.Uint32(header[12:16]),
+		cas:    binary.BigEndian.Uint64(header[16:24]),
+		extras: body[:extLen],
+		key:    string(body[extLen : extLen+keyLen]),
+		value:  body[extLen+keyLen:],
+	}, nil
+}
+
+func writeResponse(w *bufio.Writer, req *request, status uint16, cas uint64, extras []byte, key string, value []byte) {
+	if status != statusOK {
+		extras, key, value = nil, "", []byte(statusMessages[status])
+	}
+
+	header := make([]byte, headerLen)
+	header[0] = magicResponse
+	header[1] = req.opcode
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(key)))
+	header[4] = uint8(len(extras))
+	binary.BigEndian.PutUint16(header[6:8], status)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(extras)+len(key)+len(value)))
+	binary.BigEndian.PutUint32(header[12:16], req.opaque)
+	binary.BigEndian.PutUint64(header[16:24], cas)
+
+	w.Write(header)
+	w.Write(extras)
+	w.WriteString(key)
+	w.Write(value)
+}
+
+func expireAt(exp uint32, now time.Time) time.Time {
+	if exp == 0 {
+		return time.Time{}
+	}
+
+	if exp <= relativeLimit {
+		return now.Add(time.Duration(exp) * time.Second)
+	}
+
+	return time.Unix(int64(exp), 0)
+}
+
+func quiet(opcode uint8) bool {
+	switch opcode {
+	case opGetQ, opGetKQ, opSetQ, opAddQ, opReplaceQ, opDeleteQ, opIncrQ, opDecrQ,
+		opQuitQ, opFlushQ, opAppendQ, opPrependQ:
+		return true
+	}
+	return false
+}
+
+func loud(opcode uint8) uint8 {
+	switch opcode {
+	case opGetQ:
+		return opGet
+	case opGetKQ:
+		return opGetK
+	case opSetQ:
+		return opSet
+	case opAddQ:
+		return opAdd
+	case opReplaceQ:
+		return opReplace
+	case opDeleteQ:
+		return opDelete
+	case opIncrQ:
+		return opIncr
+	case opDecrQ:
+		return opDecr
+	case opQuitQ:
+		return opQuit
+	case opFlushQ:
+		return opFlush
+	case opAppendQ:
+		return opAppend
+	case opPrependQ:
+		return opPrepend
+	}
+	return opcode
+}
+
+// dispatch executes req and writes the response, it returns true when the connection should be closed.
+func (s *Server) dispatch(w *bufio.Writer, req *request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	isQuiet := quiet(req.opcode)
+	opcode := loud(req.opcode)
+	now := time.Now()
+
+	it, exists := s.items[req.key]
+	if exists && it.expired(now) {
+		delete(s.items, req.key)
+		it, exists = nil, false
+	}
+
+	reply := func(status uint16, cas uint64, extras []byte, key string, value []byte) {
+		// quiet get only answers hits, the other quiet commands only answer errors
+		if isQuiet {
+			if opcode == opGet || opcode == opGetK {
+				if status != statusOK {
+					return
+				}
+			} else if status == statusOK {
+				return
+			}
+		}
+		writeResponse(w, req, status, cas, extras, key, value)
+	}
+
+	switch opcode {
+	case opGet, opGetK:
+		if !exists {
+			reply(statusKeyNotFound, 0, nil, "", nil)
+			return false
+		}
+
+		extras := make([]byte, 4)
+		binary.BigEndian.PutUint32(extras, it.flags)
+		key := ""
+		if opcode == opGetK {
+			key = req.key
+		}
+		reply(statusOK, it.cas, extras, key, it.value)
+
+	case opSet, opAdd, opReplace:
+		if len(req.extras) != 8 || len(req.key) == 0 {
+			reply(statusInvalidArgs, 0, nil, "", nil)
+			return false
+		}
+
+		if opcode == opAdd && exists {
+			reply(statusKeyExists, 0, nil, "", nil)
+			return false
+		}
+
+		if opcode == opReplace && !exists {
+			reply(statusKeyNotFound, 0, nil, "", nil)
+			return false
+		}
+
+		if req.cas != 0 {
+			if !exists {
+				reply(statusKeyNotFound, 0, nil, "", nil)
+				return false
+			}
+
+			if it.cas != req.cas {
+				reply(statusKeyExists, 0, nil, "", nil)
+				return false
+			}
+		}
+
+		s.casSeq++
+		s.items[req.key] = &item{
+			flags:  binary.BigEndian.Uint32(req.extras[0:4]),
+			value:  append([]byte(nil), req.value...),
+			cas:    s.casSeq,
+			expire: expireAt(binary.BigEndian.Uint32(req.extras[4:8]), now),
+		}
+		reply(statusOK, s.casSeq, nil, "", nil)
+
+	case opAppend, opPrepend:
+		if !exists {
+			reply(statusNotStored, 0, nil, "", nil)
+			return false
+		}
+
+		if req.cas != 0 && it.cas != req.cas {
+			reply(statusKeyExists, 0, nil, "", nil)
+			return false
+		}
+
+		if opcode == opAppend {
+			it.value = append(it.value, req.value...)
+		} else {
+			it.value = append(append([]byte(nil), req.value...), it.value...)
+		}
+		s.casSeq++
+		it.cas = s.casSeq
+		reply(statusOK, it.cas, nil, "", nil)
+
+	case opDelete:
+		if !exists {
+			reply(statusKeyNotFound, 0, nil, "", nil)
+			return false
+		}
+
+		if req.cas != 0 && it.cas != req.cas {
+			reply(statusKeyExists, 0, nil, "", nil)
+			return false
+		}
+
+		delete(s.items, req.key)
+		reply(statusOK, 0, nil, "", nil)
+
+	case opIncr, opDecr:
+		if len(req.extras) != 20 {
+			reply(statusInvalidArgs, 0, nil, "", nil)
+			return false
+		}
+
+		delta := binary.BigEndian.Uint64(req.extras[0:8])
+		initial := binary.BigEndian.Uint64(req.extras[8:16])
+		exp := binary.BigEndian.Uint32(req.extras[16:20])
+
+		var value uint64
+		if !exists {
+			if exp == 0xffffffff {
+				reply(statusKeyNotFound, 0, nil, "", nil)
+				return false
+			}
+
+			value = initial
+			it = &item{expire: expireAt(exp, now)}
+			s.items[req.key] = it
+		} else {
+			if req.cas != 0 && it.cas != req.cas {
+				reply(statusKeyExists, 0, nil, "", nil)
+				return false
+			}
+
+			current, err := strconv.ParseUint(string(it.value), 10, 64)
+			if err != nil {
+				reply(statusNonNumeric, 0, nil, "", nil)
+				return false
+			}
+
+			if opcode == opIncr {
+				value = current + delta
+			} else if delta > current {
+				value = 0
+			} else {
+				value = current - delta
+			}
+		}
+
+		s.casSeq++
+		it.cas = s.casSeq
+		it.value = []byte(strconv.FormatUint(value, 10))
+		body := make([]byte, 8)
+		binary.BigEndian.PutUint64(body, value)
+		reply(statusOK, it.cas, nil, "", body)
+
+	case opTouch:
+		if len(req.extras) != 4 {
+			reply(statusInvalidArgs, 0, nil, "", nil)
+			return false
+		}
+
+		if !exists {
+			reply(statusKeyNotFound, 0, nil, "", nil)
+			return false
+		}
+
+		it.expire = expireAt(binary.BigEndian.Uint32(req.extras), now)
+		reply(statusOK, it.cas, nil, "", nil)
+
+	case opFlush:
+		var exp uint32
+		if len(req.extras) == 4 {
+			exp = binary.BigEndian.Uint32(req.extras)
+		}
+
+		if exp == 0 {
+			s.items = make(map[string]*item)
+		} else {
+			at := expireAt(exp, now)
+			for _, it := range s.items {
+				if it.expire.IsZero() || it.expire.After(at) {
+					it.expire = at
+				}
+			}
+		}
+		reply(statusOK, 0, nil, "", nil)
+
+	case opNoop:
+		reply(statusOK, 0, nil, "", nil)
+
+	case opVersion:
+		reply(statusOK, 0, nil, "", []byte("1.6.0-fake"))
+
+	case opQuit:
+		reply(statusOK, 0, nil, "", nil)
+		return true
+
+	default:
+		reply(statusUnknownCmd, 0, nil, "", nil)
+	}
+
+	return false
+}
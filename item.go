@@ -0,0 +1,43 @@
+package gomemcached
+
+// Item is a raw item of memcached, its value and flags are stored and returned as they are,
+// so the items can be shared with other clients which encode type information in flags.
+type Item struct {
+	Key   string
+	Value []byte
+	Flags uint32
+	CAS   uint64
+	// Expiration of SetItem in the encoding of memcached, it is not returned by GetItem.
+	Expiration uint32
+}
+
+func (m *MemcachedClient) GetItem(key string) (*Item, error) {
+	checked, err := m.checkKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	flag, data, cas, err := m.getCachedRaw(checked, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// data is returned as stored without checking tags, since other clients may use the flag of tagged values.
+	// data may be shared by near cache and coalesced callers
+	return &Item{
+		Key:   key,
+		Value: append([]byte(nil), data...),
+		Flags: flag,
+		CAS:   cas,
+	}, nil
+}
+
+func (m *MemcachedClient) SetItem(item *Item) (uint64, error) {
+	return m.store(OPCODE_SET, &KeyArgs{
+		Key:        item.Key,
+		Value:      item.Value,
+		Expiration: item.Expiration,
+		CAS:        item.CAS,
+		flag:       item.Flags,
+	}, false, nil)
+}
@@ -0,0 +1,59 @@
+package gomemcached
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestItem(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	cas, err := m.SetItem(&Item{Key: "TestItem", Value: []byte("HelloWorld"), Flags: 0x1234})
+	if err != nil {
+		t.Fatalf("SetItem err: %v", err)
+	}
+	if value, flags, ok := servers[0].Value("TestItem"); !ok || string(value) != "HelloWorld" || flags != 0x1234 {
+		t.Errorf("server holds: %s, %x, %v", value, flags, ok)
+	}
+
+	item, err := m.GetItem("TestItem")
+	if err != nil {
+		t.Fatalf("GetItem err: %v", err)
+	}
+	if item.Key != "TestItem" || string(item.Value) != "HelloWorld" || item.Flags != 0x1234 || item.CAS != cas {
+		t.Errorf("GetItem: %+v", item)
+	}
+
+	// CAS of item guards the update
+	item.Value = []byte("HelloAgain")
+	if _, err := m.SetItem(item); err != nil {
+		t.Fatalf("SetItem with CAS err: %v", err)
+	}
+	if _, err := m.SetItem(item); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("SetItem with stale CAS: %v", err)
+	}
+
+	// the items of Set are tagged by USE_MSGP_FLAG
+	if _, err := m.Set(&KeyArgs{Key: "TestItemTyped", Value: "HelloWorld"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+	if item, err := m.GetItem("TestItemTyped"); err != nil || item.Flags != USE_MSGP_FLAG {
+		t.Errorf("GetItem: %+v, %v", item, err)
+	}
+
+	// the flag of tagged values is returned as it is
+	if _, err := m.SetItem(&Item{Key: "TestItemTagFlag", Value: []byte("42"), Flags: USE_TAGGED_FLAG}); err != nil {
+		t.Fatalf("SetItem err: %v", err)
+	}
+	if item, err := m.GetItem("TestItemTagFlag"); err != nil || string(item.Value) != "42" || item.Flags != USE_TAGGED_FLAG {
+		t.Errorf("GetItem: %+v, %v", item, err)
+	}
+
+	if _, err := m.GetItem("TestItemMissing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("GetItem of missing key: %v", err)
+	}
+}
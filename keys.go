@@ -0,0 +1,71 @@
+package gomemcached
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// MaxKeyLength is the max length of key accepted by memcached.
+const MaxKeyLength = 250
+
+// validateKey rejects keys which are empty or contain spaces or control characters,
+// they break the interoperability with text protocol clients.
+// The length is checked by checkKey because long keys may be hashed.
+func validateKey(key string) error {
+	if len(key) <= 0 {
+		return fmt.Errorf("%w: key is empty", ErrInvalidKey)
+	}
+
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c <= ' ' || c == 0x7f {
+			return fmt.Errorf("%w: key %q contains space or control character at %v", ErrInvalidKey, key, i)
+		}
+	}
+
+	return nil
+}
+
+// hashLongKey replaces key with a prefix of it and its SHA-256 digest, the result is exactly MaxKeyLength bytes.
+func hashLongKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	suffix := hex.EncodeToString(digest[:])
+	return key[:MaxKeyLength-len(suffix)] + suffix
+}
+
+// checkKey validates key before any connection is checked out,
+// the returned key is the one sent to server.
+func (m *MemcachedClient) checkKey(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	if len(key) > MaxKeyLength {
+		if !m.hashLongKeys {
+			return "", fmt.Errorf("%w: key of %v bytes exceeds %v bytes", ErrInvalidKey, len(key), MaxKeyLength)
+		}
+		return hashLongKey(key), nil
+	}
+
+	return key, nil
+}
+
+// checkArgs returns a copy of args whose key is checked by checkKey,
+// and whose TTL or ExpiresAt is converted to Expiration.
+func (m *MemcachedClient) checkArgs(args *KeyArgs) (*KeyArgs, error) {
+	key, err := m.checkKey(args.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	checked := *args
+	checked.Key = key
+	if err := resolveExpiration(&checked, m.ttlJitter); err != nil {
+		return nil, err
+	}
+	return &checked, nil
+}
+
+func (m *MemcachedClient) SetLongKeyHashing(enable bool) {
+	m.hashLongKeys = enable
+}
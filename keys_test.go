@@ -0,0 +1,61 @@
+package gomemcached
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyValidation(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	longKey := strings.Repeat("k", MaxKeyLength+1)
+	for _, key := range []string{"", "Hello World", "Hello\r\nWorld", "Hello\x7f", longKey} {
+		var value string
+		if _, err := m.Get(key, &value); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get %q: %v", key, err)
+		}
+		if _, err := m.Set(&KeyArgs{Key: key, Value: "HelloWorld"}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Set %q: %v", key, err)
+		}
+	}
+
+	if n := servers[0].OpCount(OPCODE_GET) + servers[0].OpCount(OPCODE_SET); n != 0 {
+		t.Errorf("invalid keys are sent %v times", n)
+	}
+
+	errs := m.SetMulti([]*KeyArgs{{Key: "TestKeyValidation", Value: 1}, {Key: "Hello World", Value: 2}})
+	if len(errs) != 1 || !errors.Is(errs["Hello World"], ErrInvalidKey) {
+		t.Errorf("SetMulti errs: %v", errs)
+	}
+
+	// only length is fixed by hashing
+	m.SetLongKeyHashing(true)
+	if _, err := m.Set(&KeyArgs{Key: "Hello World", Value: "HelloWorld"}); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Set key with space: %v", err)
+	}
+
+	if _, err := m.Set(&KeyArgs{Key: longKey, Value: "HelloWorld"}); err != nil {
+		t.Fatalf("Set long key: %v", err)
+	}
+
+	var value string
+	if _, err := m.Get(longKey, &value); err != nil || value != "HelloWorld" {
+		t.Fatalf("Get long key: %v, %v", value, err)
+	}
+
+	hashed := hashLongKey(longKey)
+	if len(hashed) != MaxKeyLength || !strings.HasPrefix(hashed, "kkk") {
+		t.Errorf("hashed key: %v", hashed)
+	}
+	if _, _, ok := servers[0].Value(hashed); !ok {
+		t.Errorf("server does not store the hashed key")
+	}
+	if hashLongKey(longKey+"k") == hashed {
+		t.Errorf("different keys are hashed to the same key")
+	}
+}
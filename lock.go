@@ -0,0 +1,204 @@
+package gomemcached
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	lockKeyPrefix = "lock:"
+	fenceSuffix   = "#fence"
+)
+
+// Locker is a distributed lock built on memcached.
+// A lock is an item added by `AddRawData`, it is renewed by `SetRawData` with CAS in background
+// and deleted with CAS, so a lock which expired and was taken by another owner is never touched.
+type Locker struct {
+	client Client
+	// Prefix of lock keys.
+	Prefix string
+	// How often a blocking Lock retries when the lock is held by another owner.
+	RetryInterval time.Duration
+}
+
+func NewLocker(client Client) *Locker {
+	return &Locker{
+		client:        client,
+		Prefix:        lockKeyPrefix,
+		RetryInterval: time.Duration(100) * time.Millisecond,
+	}
+}
+
+// Lock is a held lock.
+type Lock struct {
+	client Client
+	key    string
+	token  uint64
+	ttl    time.Duration
+	cas    uint64
+	quitF  context.CancelFunc
+	lost   chan struct{}
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+// TryLock acquires lock `name` once, returns ErrLockHeld when it is held by another owner.
+func (l *Locker) TryLock(name string, ttl time.Duration) (*Lock, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidArguments
+	}
+
+	key := l.Prefix + name
+
+	// fencing token strictly increases with every acquirement,
+	// a counter which was evicted restarts from current time, so it does not go back to the tokens issued before
+	token, _, err := l.client.Increment(&KeyArgs{
+		Key:     key + fenceSuffix,
+		Delta:   1,
+		Initial: uint64(time.Now().UnixNano()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cas, err := l.client.AddRawData(&KeyArgs{
+		Key:        key,
+		Value:      []byte(strconv.FormatUint(token, 10)),
+		Expiration: lockExpiration(ttl),
+	})
+	if errors.Is(err, ErrKeyExists) || errors.Is(err, ErrItemNotStored) {
+		return nil, ErrLockHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, quitF := context.WithCancel(context.Background())
+	lk := &Lock{
+		client: l.client,
+		key:    key,
+		token:  token,
+		ttl:    ttl,
+		cas:    cas,
+		quitF:  quitF,
+		lost:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go lk.renew(ctx)
+
+	return lk, nil
+}
+
+// Lock blocks until lock `name` is acquired or ctx is done.
+func (l *Locker) Lock(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	for {
+		lk, err := l.TryLock(name, ttl)
+		if !errors.Is(err, ErrLockHeld) {
+			return lk, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(l.RetryInterval):
+		}
+	}
+}
+
+// Token returns the fencing token of lock,
+// the resource guarded by lock should reject the requests with an older token.
+func (lk *Lock) Token() uint64 {
+	return lk.token
+}
+
+// Lost is closed when the lock can not be renewed because it is held by another owner or it has expired.
+func (lk *Lock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// Unlock stops renewal and deletes lock if it is still held,
+// otherwise ErrLockNotHeld is returned.
+func (lk *Lock) Unlock() error {
+	lk.quitF()
+	<-lk.done
+
+	select {
+	case <-lk.lost:
+		return ErrLockNotHeld
+	default:
+	}
+
+	lk.mu.Lock()
+	cas := lk.cas
+	lk.mu.Unlock()
+
+	err := lk.client.Delete(&KeyArgs{Key: lk.key, CAS: cas})
+	if errors.Is(err, ErrKeyExists) || errors.Is(err, ErrKeyNotFound) {
+		return ErrLockNotHeld
+	}
+
+	return err
+}
+
+func (lk *Lock) renew(ctx context.Context) {
+	defer close(lk.done)
+
+	interval := lk.ttl / 3
+	if interval <= 0 {
+		interval = lk.ttl
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		held, err := lk.touch()
+		if err != nil {
+			// try again at next tick, the lock is lost if it expired meanwhile
+			continue
+		}
+
+		if !held {
+			close(lk.lost)
+			return
+		}
+	}
+}
+
+// touch renews the lock only if it still has the CAS of last acquirement or renewal,
+// it is never read by Get which may answer from near cache.
+func (lk *Lock) touch() (bool, error) {
+	lk.mu.Lock()
+	defer lk.mu.Unlock()
+
+	cas, err := lk.client.SetRawData(&KeyArgs{
+		Key:        lk.key,
+		Value:      []byte(strconv.FormatUint(lk.token, 10)),
+		Expiration: lockExpiration(lk.ttl),
+		CAS:        lk.cas,
+	})
+	if errors.Is(err, ErrKeyExists) || errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	lk.cas = cas
+	return true, nil
+}
+
+func lockExpiration(ttl time.Duration) uint32 {
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttlExpiration(ttl, time.Now())
+}
@@ -0,0 +1,168 @@
+package gomemcached
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLocker(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 5)
+	defer m.Exit()
+
+	locker := NewLocker(m)
+	lk, err := locker.TryLock("TestLocker", time.Second)
+	if err != nil {
+		t.Fatalf("TryLock err: %v", err)
+	}
+
+	_, err = locker.TryLock("TestLocker", time.Second)
+	if !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("TryLock held lock err: %v", err)
+	}
+
+	// lock is renewed beyond its ttl
+	time.Sleep(time.Millisecond * 1500)
+	select {
+	case <-lk.Lost():
+		t.Fatalf("lock is lost")
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	_, err = locker.Lock(ctx, "TestLocker", time.Second)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Lock held lock err: %v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		lk.Unlock()
+	}()
+
+	lk2, err := locker.Lock(context.Background(), "TestLocker", time.Second)
+	if err != nil {
+		t.Fatalf("Lock err: %v", err)
+	}
+
+	if lk2.Token() <= lk.Token() {
+		t.Fatalf("token does not increase: %v, %v", lk.Token(), lk2.Token())
+	}
+
+	if err := lk.Unlock(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Unlock twice err: %v", err)
+	}
+
+	if err := lk2.Unlock(); err != nil {
+		t.Fatalf("Unlock err: %v", err)
+	}
+}
+
+func TestLockerLost(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 5)
+	defer m.Exit()
+
+	locker := NewLocker(m)
+	lk, err := locker.TryLock("TestLockerLost", time.Second)
+	if err != nil {
+		t.Fatalf("TryLock err: %v", err)
+	}
+
+	// another owner takes over the lock
+	_, err = m.SetRawData(&KeyArgs{Key: locker.Prefix + "TestLockerLost", Value: []byte("0")})
+	if err != nil {
+		t.Fatalf("SetRawData err: %v", err)
+	}
+
+	select {
+	case <-lk.Lost():
+	case <-time.After(time.Second * 2):
+		t.Fatalf("lock is not lost")
+	}
+
+	if err := lk.Unlock(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Unlock err: %v", err)
+	}
+
+	if _, _, ok := servers[0].Value(locker.Prefix + "TestLockerLost"); !ok {
+		t.Fatalf("lock of another owner is deleted")
+	}
+}
+
+func TestLockerLostNearCache(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 5)
+	defer m.Exit()
+	m.SetNearCache(100, 0, time.Minute)
+
+	other := NewMemcachedClient(fakeServerAddrs(servers), 1)
+	defer other.Exit()
+
+	locker := NewLocker(m)
+	lk, err := locker.TryLock("TestLockerLost", time.Second)
+	if err != nil {
+		t.Fatalf("TryLock err: %v", err)
+	}
+
+	// the lock is in near cache of the owner
+	var value []byte
+	if _, err := m.Get(locker.Prefix+"TestLockerLost", &value); err != nil {
+		t.Fatalf("Get err: %v", err)
+	}
+
+	// another client takes over the lock
+	_, err = other.SetRawData(&KeyArgs{Key: locker.Prefix + "TestLockerLost", Value: []byte("0")})
+	if err != nil {
+		t.Fatalf("SetRawData err: %v", err)
+	}
+
+	select {
+	case <-lk.Lost():
+	case <-time.After(time.Second * 2):
+		t.Fatalf("lock is not lost")
+	}
+
+	if err := lk.Unlock(); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("Unlock err: %v", err)
+	}
+}
+
+func TestLockerFenceEvicted(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 1)
+	defer m.Exit()
+
+	locker := NewLocker(m)
+	lk, err := locker.TryLock("TestLockerFenceEvicted", time.Second)
+	if err != nil {
+		t.Fatalf("TryLock err: %v", err)
+	}
+	lk.Unlock()
+
+	// the fencing counter is evicted by memcached
+	if err := m.Delete(&KeyArgs{Key: locker.Prefix + "TestLockerFenceEvicted" + fenceSuffix}); err != nil {
+		t.Fatalf("Delete fence err: %v", err)
+	}
+
+	lk2, err := locker.TryLock("TestLockerFenceEvicted", time.Second)
+	if err != nil {
+		t.Fatalf("TryLock err: %v", err)
+	}
+	defer lk2.Unlock()
+
+	if lk2.Token() <= lk.Token() {
+		t.Fatalf("token goes back after eviction: %v, %v", lk.Token(), lk2.Token())
+	}
+}
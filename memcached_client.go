@@ -1,12 +1,34 @@
 package gomemcached
 
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
 type MemcachedClient struct {
-	cluster *Cluster
+	cluster            *Cluster
+	replicas           int
+	writeAck           WriteAck
+	partialWritePolicy PartialWritePolicy
+	nearCache          *nearCache
+	flights            *flightGroup
+	retryPolicy        *RetryPolicy
+	asyncSem           chan struct{}
+	closeMu            sync.Mutex
+	closed             bool
+	inflight           int
+	drained            chan struct{}
+	hashLongKeys       bool
+	ttlJitter          float64
+	hedging            *hedger
 }
 
 func NewMemcachedClient(addrs []string, maxConnPerServer uint32) Client {
 	m := &MemcachedClient{}
 	m.cluster = createCluster(addrs, maxConnPerServer)
+	m.asyncSem = make(chan struct{}, AsyncConcurrency)
 	return m
 }
 
@@ -18,22 +40,68 @@ func (m *MemcachedClient) SetServerErrorCallback(errCall ServerErrorCallback) {
 	m.cluster.serverErrCallback = errCall
 }
 
+func (m *MemcachedClient) SetServerResolver(resolver ServerResolver, interval time.Duration) {
+	m.cluster.setServerResolver(resolver, interval)
+}
+
 func (m *MemcachedClient) Exit() {
-	m.cluster.exit()
+	m.Close(context.Background())
 }
 
-func (m *MemcachedClient) exec(key string, cmdFunc func(cmder *Commander) error) error {
-	var err error
-	server, cmder, err := m.cluster.ChooseServerCommanderByKey(key)
-	if err != nil {
+func (m *MemcachedClient) exec(opCode uint8, key string, cmdFunc func(cmder *Commander) error) error {
+	return m.execCAS(opCode, key, 0, cmdFunc)
+}
+
+// execCAS executes the operation of key guarded by `cas`, which is not retried as idempotent.
+func (m *MemcachedClient) execCAS(opCode uint8, key string, cas uint64, cmdFunc func(cmder *Commander) error) error {
+	if err := m.enter(); err != nil {
+		return err
+	}
+	defer m.leave()
+
+	return m.retry(opCode, cas, func() error {
+		server, cmder, err := m.cluster.chooseCommanderByKey(key, isRead(opCode))
+		if err != nil {
+			return newOpError(opCode, key, serverAddr(server), err)
+		}
+
+		return newOpError(opCode, key, server.Addr, m.run(server, cmder, cmdFunc))
+	})
+}
+
+// execServer executes the operation of key on server `addr`, key is only used by errors.
+func (m *MemcachedClient) execServer(opCode uint8, key string, addr string, cmdFunc func(cmder *Commander) error) error {
+	return m.execServerCAS(opCode, key, addr, 0, cmdFunc)
+}
+
+// execServerCAS is execServer of the operation guarded by `cas`.
+func (m *MemcachedClient) execServerCAS(opCode uint8, key string, addr string, cas uint64, cmdFunc func(cmder *Commander) error) error {
+	if err := m.enter(); err != nil {
 		return err
 	}
+	defer m.leave()
+
+	return m.retry(opCode, cas, func() error {
+		server, cmder, err := m.cluster.ChooseServerCommanderByServerAddr(addr)
+		if err != nil {
+			return newOpError(opCode, key, addr, err)
+		}
+
+		return newOpError(opCode, key, addr, m.run(server, cmder, cmdFunc))
+	})
+}
 
+func (m *MemcachedClient) run(server *Server, cmder *Commander, cmdFunc func(cmder *Commander) error) error {
+	var err error
 	defer func() {
+		server.done(cmder.breakerGen, err)
+		var callerErr *callerError
 		if err == nil {
 			m.cluster.ReleaseServerCommander(server, cmder)
-		} else if _, ok := err.(*StatusError); ok {
+		} else if isStatusError(err) {
 			m.cluster.ReleaseServerCommander(server, cmder)
+		} else if errors.As(err, &callerErr) {
+			m.cluster.renewCmder(server, cmder)
 		} else {
 			cmder.Giveup()
 		}
@@ -44,89 +112,225 @@ func (m *MemcachedClient) exec(key string, cmdFunc func(cmder *Commander) error)
 }
 
 func (m *MemcachedClient) Get(key string, value interface{}) (uint64, error) {
+	key, err := m.checkKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if m.nearCache != nil || m.flights != nil || m.hedging != nil {
+		flag, data, cas, err := m.getCachedRaw(key, nil)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := m.checkRawTags(flag, data); err != nil {
+			return 0, err
+		}
+
+		return cas, decodeValue(flag, data, value)
+	}
+
 	var modifyCAS uint64
+	var tags map[string]uint64
 	var resErr error
 
-	err := m.exec(key, func(cmder *Commander) error {
-		modifyCAS, resErr = cmder.get(key, value)
+	cmdFunc := func(cmder *Commander) error {
+		modifyCAS, tags, resErr = cmder.get(key, value)
 		return resErr
-	})
+	}
 
-	return modifyCAS, err
+	if m.replicas > 1 {
+		err = m.execReplicasRead(OPCODE_GET, key, cmdFunc)
+	} else {
+		err = m.exec(OPCODE_GET, key, cmdFunc)
+	}
+	if err != nil {
+		return modifyCAS, err
+	}
+
+	// the tags are checked after the connection is released
+	if err := m.checkTags(tags); err != nil {
+		return 0, err
+	}
+	return modifyCAS, nil
 }
 
-func (m *MemcachedClient) Set(args *KeyArgs) (uint64, error) {
-	var modifyCAS uint64
-	var resErr error
+// getCachedRaw returns the raw value of key from near cache or memcached,
+// the request is cancelled when cancel is closed unless it is shared by the coalesced callers.
+func (m *MemcachedClient) getCachedRaw(key string, cancel <-chan struct{}) (uint32, []byte, uint64, error) {
+	if m.isClosed() {
+		return 0, nil, 0, ErrClientClosed
+	}
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
-		args.useMsgpack = true
-		modifyCAS, resErr = cmder.store(OPCODE_SET, args)
-		return resErr
-	})
+	nc := m.nearCache
+	if nc != nil {
+		if flag, data, cas, ok := nc.get(key); ok {
+			return flag, data, cas, nil
+		}
+	}
 
-	return modifyCAS, err
+	// the returned data must not be modified because it may be shared by the coalesced callers,
+	// it is cached by the caller which fetched it
+	fetch := func() (uint32, []byte, uint64, error) {
+		if nc == nil {
+			return m.fetchRaw(key, cancel)
+		}
+
+		// the value fetched before a concurrent write invalidates key may be stale
+		epoch := nc.epoch(key)
+		flag, data, cas, err := m.fetchRaw(key, cancel)
+		if err == nil {
+			nc.put(key, epoch, flag, data, cas)
+		}
+		return flag, data, cas, err
+	}
+
+	if m.flights != nil {
+		// the fetch shared by the coalesced callers is not cancelled by one of them
+		cancel = nil
+		return m.flights.do(key, fetch)
+	}
+
+	return fetch()
 }
 
-func (m *MemcachedClient) SetRawData(args *KeyArgs) (uint64, error) {
+func (m *MemcachedClient) fetchRaw(key string, cancel <-chan struct{}) (uint32, []byte, uint64, error) {
+	if h := m.hedging; h != nil {
+		return m.fetchHedged(key, h, cancel)
+	}
+
+	var flag uint32
+	var data []byte
 	var modifyCAS uint64
 	var resErr error
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
-		args.useMsgpack = false
-		modifyCAS, resErr = cmder.store(OPCODE_SET, args)
+	cmdFunc := cancellable(cancel, context.Canceled, func(cmder *Commander) error {
+		flag, data, modifyCAS, resErr = cmder.getRaw(key)
 		return resErr
 	})
 
-	return modifyCAS, err
+	var err error
+	if m.replicas > 1 {
+		err = m.execReplicasRead(OPCODE_GET, key, cmdFunc)
+	} else {
+		err = m.exec(OPCODE_GET, key, cmdFunc)
+	}
+
+	return flag, data, modifyCAS, err
 }
 
-func (m *MemcachedClient) Add(args *KeyArgs) (uint64, error) {
+// store writes args by opCode, the request is cancelled when cancel is closed.
+func (m *MemcachedClient) store(opCode uint8, args *KeyArgs, useMsgpack bool, cancel <-chan struct{}) (uint64, error) {
+	args, err := m.checkArgs(args)
+	if err != nil {
+		return 0, err
+	}
+
 	var modifyCAS uint64
-	var resErr error
+	storeArgs := *args
+	storeArgs.useMsgpack = useMsgpack
+	defer m.invalidateNearCache(args.Key)
+	if m.replicas > 1 {
+		// only primary server checks the condition of Add/Replace/CAS,
+		// replicas just follow the result of primary server
+		conditional := opCode != OPCODE_SET || args.CAS != 0
+		replicaArgs := storeArgs
+		replicaArgs.CAS = 0
+
+		err := m.execReplicasWrite(opCode, args.Key, conditional, args.CAS, func(cmder *Commander, primary bool) error {
+			return cancellable(cancel, context.Canceled, func(cmder *Commander) error {
+				if !primary {
+					_, err := cmder.store(OPCODE_SET, &replicaArgs)
+					return err
+				}
+
+				var err error
+				modifyCAS, err = cmder.store(opCode, &storeArgs)
+				return err
+			})(cmder)
+		})
+
+		return modifyCAS, err
+	}
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
-		args.useMsgpack = true
-		modifyCAS, resErr = cmder.store(OPCODE_ADD, args)
+	var resErr error
+	err = m.execCAS(opCode, args.Key, args.CAS, cancellable(cancel, context.Canceled, func(cmder *Commander) error {
+		modifyCAS, resErr = cmder.store(opCode, &storeArgs)
 		return resErr
-	})
+	}))
 
 	return modifyCAS, err
 }
 
-func (m *MemcachedClient) AddRawData(args *KeyArgs) (uint64, error) {
-	var modifyCAS uint64
-	var resErr error
+func (m *MemcachedClient) Set(args *KeyArgs) (uint64, error) {
+	return m.store(OPCODE_SET, args, true, nil)
+}
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
-		args.useMsgpack = false
-		modifyCAS, resErr = cmder.store(OPCODE_ADD, args)
-		return resErr
-	})
+func (m *MemcachedClient) SetRawData(args *KeyArgs) (uint64, error) {
+	return m.store(OPCODE_SET, args, false, nil)
+}
 
-	return modifyCAS, err
+func (m *MemcachedClient) Add(args *KeyArgs) (uint64, error) {
+	return m.store(OPCODE_ADD, args, true, nil)
+}
+
+func (m *MemcachedClient) AddRawData(args *KeyArgs) (uint64, error) {
+	return m.store(OPCODE_ADD, args, false, nil)
 }
 
 func (m *MemcachedClient) Replace(args *KeyArgs) (uint64, error) {
-	var modifyCAS uint64
-	var resErr error
+	return m.store(OPCODE_REPLACE, args, true, nil)
+}
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
-		args.useMsgpack = true
-		modifyCAS, resErr = cmder.store(OPCODE_REPLACE, args)
-		return resErr
-	})
+func (m *MemcachedClient) ReplaceRawData(args *KeyArgs) (uint64, error) {
+	return m.store(OPCODE_REPLACE, args, false, nil)
+}
 
-	return modifyCAS, err
+func (m *MemcachedClient) Delete(args *KeyArgs) error {
+	args, err := m.checkArgs(args)
+	if err != nil {
+		return err
+	}
+
+	defer m.invalidateNearCache(args.Key)
+
+	if m.replicas > 1 {
+		return m.execReplicasWrite(OPCODE_DEL, args.Key, args.CAS != 0, args.CAS, func(cmder *Commander, primary bool) error {
+			if !primary {
+				return cmder.delete(args.Key, 0)
+			}
+			return cmder.delete(args.Key, args.CAS)
+		})
+	}
+
+	return m.execCAS(OPCODE_DEL, args.Key, args.CAS, func(cmder *Commander) error {
+		return cmder.delete(args.Key, args.CAS)
+	})
 }
 
-func (m *MemcachedClient) ReplaceRawData(args *KeyArgs) (uint64, error) {
+func (m *MemcachedClient) Touch(args *KeyArgs) (uint64, error) {
+	args, err := m.checkArgs(args)
+	if err != nil {
+		return 0, err
+	}
+
 	var modifyCAS uint64
-	var resErr error
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
-		args.useMsgpack = false
-		modifyCAS, resErr = cmder.store(OPCODE_REPLACE, args)
+	if m.replicas > 1 {
+		err := m.execReplicasWrite(OPCODE_TOUCH, args.Key, false, 0, func(cmder *Commander, primary bool) error {
+			cas, err := cmder.touch(args)
+			if primary {
+				modifyCAS = cas
+			}
+			return err
+		})
+
+		return modifyCAS, err
+	}
+
+	var resErr error
+	err = m.exec(OPCODE_TOUCH, args.Key, func(cmder *Commander) error {
+		modifyCAS, resErr = cmder.touch(args)
 		return resErr
 	})
 
@@ -134,10 +338,16 @@ func (m *MemcachedClient) ReplaceRawData(args *KeyArgs) (uint64, error) {
 }
 
 func (m *MemcachedClient) Append(args *KeyArgs) (uint64, error) {
+	args, err := m.checkArgs(args)
+	if err != nil {
+		return 0, err
+	}
+	defer m.invalidateNearCache(args.Key)
+
 	var modifyCAS uint64
 	var resErr error
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
+	err = m.exec(OPCODE_APPEND, args.Key, func(cmder *Commander) error {
 		modifyCAS, resErr = cmder.append(OPCODE_APPEND, args)
 		return resErr
 	})
@@ -146,10 +356,16 @@ func (m *MemcachedClient) Append(args *KeyArgs) (uint64, error) {
 }
 
 func (m *MemcachedClient) Prepend(args *KeyArgs) (uint64, error) {
+	args, err := m.checkArgs(args)
+	if err != nil {
+		return 0, err
+	}
+	defer m.invalidateNearCache(args.Key)
+
 	var modifyCAS uint64
 	var resErr error
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
+	err = m.exec(OPCODE_PREPEND, args.Key, func(cmder *Commander) error {
 		modifyCAS, resErr = cmder.append(OPCODE_PREPEND, args)
 		return resErr
 	})
@@ -158,11 +374,17 @@ func (m *MemcachedClient) Prepend(args *KeyArgs) (uint64, error) {
 }
 
 func (m *MemcachedClient) Increment(args *KeyArgs) (uint64, uint64, error) {
+	args, err := m.checkArgs(args)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer m.invalidateNearCache(args.Key)
+
 	var value uint64
 	var modifyCAS uint64
 	var resErr error
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
+	err = m.exec(OPCODE_INCR, args.Key, func(cmder *Commander) error {
 		value, modifyCAS, resErr = cmder.atomic(OPCODE_INCR, args)
 		return resErr
 	})
@@ -171,11 +393,17 @@ func (m *MemcachedClient) Increment(args *KeyArgs) (uint64, uint64, error) {
 }
 
 func (m *MemcachedClient) Decrement(args *KeyArgs) (uint64, uint64, error) {
+	args, err := m.checkArgs(args)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer m.invalidateNearCache(args.Key)
+
 	var value uint64
 	var modifyCAS uint64
 	var resErr error
 
-	err := m.exec(args.Key, func(cmder *Commander) error {
+	err = m.exec(OPCODE_DECR, args.Key, func(cmder *Commander) error {
 		value, modifyCAS, resErr = cmder.atomic(OPCODE_DECR, args)
 		return resErr
 	})
@@ -184,10 +412,15 @@ func (m *MemcachedClient) Decrement(args *KeyArgs) (uint64, uint64, error) {
 }
 
 func (m *MemcachedClient) TouchAtomicValue(key string) (uint64, error) {
+	key, err := m.checkKey(key)
+	if err != nil {
+		return 0, err
+	}
+
 	var value uint64
 	var resErr error
 
-	err := m.exec(key, func(cmder *Commander) error {
+	err = m.exec(OPCODE_GET, key, func(cmder *Commander) error {
 		value, resErr = cmder.touchAtomicValue(key)
 		return resErr
 	})
@@ -195,29 +428,65 @@ func (m *MemcachedClient) TouchAtomicValue(key string) (uint64, error) {
 	return value, err
 }
 
+// Flush flushes every server concurrently, the failure of a server does not stop the others.
+// Return value is a ServerErrors of the failed servers.
 func (m *MemcachedClient) Flush(args *KeyArgs) error {
-	addrs := m.cluster.getServerAddrs()
-	for _, addr := range addrs {
-		server, cmder, err := m.cluster.ChooseServerCommanderByServerAddr(addr)
-		if err != nil {
-			return err
-		}
+	flushArgs := KeyArgs{}
+	if args != nil {
+		flushArgs = *args
+	}
+	// the delay of flush is never jittered
+	if err := resolveExpiration(&flushArgs, 0); err != nil {
+		return err
+	}
 
-		err = cmder.flush(args)
-		defer func() {
-			if err == nil {
-				m.cluster.ReleaseServerCommander(server, cmder)
-			} else if _, ok := err.(*StatusError); ok {
-				m.cluster.ReleaseServerCommander(server, cmder)
-			} else {
-				cmder.Giveup()
-			}
-		}()
+	if m.nearCache != nil {
+		defer m.nearCache.clear()
+	}
+
+	addrs := m.cluster.getServerAddrs()
+	errs := make([]error, len(addrs))
+	var wg sync.WaitGroup
+	for i := range addrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = m.flushServer(addrs[i], &flushArgs)
+		}(i)
+	}
+	wg.Wait()
 
+	failed := make(ServerErrors)
+	for i, err := range errs {
 		if err != nil {
-			return err
+			failed[addrs[i]] = err
 		}
 	}
 
+	if len(failed) > 0 {
+		return failed
+	}
 	return nil
 }
+
+// FlushServer flushes the items of server `addr` now.
+func (m *MemcachedClient) FlushServer(addr string) error {
+	if m.nearCache != nil {
+		defer m.nearCache.clear()
+	}
+
+	return m.flushServer(addr, &KeyArgs{})
+}
+
+func (m *MemcachedClient) flushServer(addr string, args *KeyArgs) error {
+	return m.execServer(OPCODE_FLUSH, "", addr, func(cmder *Commander) error {
+		return cmder.flush(args)
+	})
+}
+
+func serverAddr(s *Server) string {
+	if s == nil {
+		return ""
+	}
+	return s.Addr
+}
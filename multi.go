@@ -0,0 +1,146 @@
+package gomemcached
+
+import (
+	"sync"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+// MultiBatchSize is the max number of quiet requests sent to a server in one round trip.
+var MultiBatchSize = 128
+
+func (m *MemcachedClient) SetMulti(items []*KeyArgs) map[string]error {
+	keys := make([]string, len(items))
+	for i, args := range items {
+		keys[i] = args.Key
+	}
+
+	return m.execMulti(OPCODE_SETQ, keys, func(req *bytebufferpool.ByteBuffer, i int, key string, opaque uint32) error {
+		args := *items[i]
+		args.Key = key
+		args.useMsgpack = true
+		if err := resolveExpiration(&args, m.ttlJitter); err != nil {
+			return err
+		}
+		return writeStoreReq(req, OPCODE_SETQ, &args, opaque)
+	})
+}
+
+func (m *MemcachedClient) DeleteMulti(keys []string) map[string]error {
+	return m.execMulti(OPCODE_DELQ, keys, func(req *bytebufferpool.ByteBuffer, i int, key string, opaque uint32) error {
+		writeDeleteReq(req, OPCODE_DELQ, key, 0, opaque)
+		return nil
+	})
+}
+
+// execMulti sends the quiet write requests of keys to their servers concurrently,
+// `writeReq` appends the request of i-th key to batch, `key` is the checked key.
+// A key fails when it is invalid or its servers did not satisfy the write ack of replication.
+func (m *MemcachedClient) execMulti(opCode uint8, keys []string, writeReq func(req *bytebufferpool.ByteBuffer, i int, key string, opaque uint32) error) map[string]error {
+	errs := make(map[string]error)
+	checked := make([]string, len(keys))
+	for i, key := range keys {
+		var err error
+		if checked[i], err = m.checkKey(key); err != nil {
+			errs[key] = err
+			continue
+		}
+		defer m.invalidateNearCache(checked[i])
+	}
+
+	groups, counts := m.cluster.groupKeysByServer(checked, m.replicas)
+	write := func(req *bytebufferpool.ByteBuffer, i int, opaque uint32) error {
+		return writeReq(req, i, checked[i], opaque)
+	}
+
+	failures := make([][]error, len(keys))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for s, indexes := range groups {
+		wg.Add(1)
+		go func(s *Server, indexes []int) {
+			defer wg.Done()
+			record := func(i int, err error) {
+				mu.Lock()
+				failures[i] = append(failures[i], newOpError(opCode, checked[i], s.Addr, err))
+				mu.Unlock()
+			}
+
+			for start := 0; start < len(indexes); start += MultiBatchSize {
+				end := start + MultiBatchSize
+				if end > len(indexes) {
+					end = len(indexes)
+				}
+				m.execBatch(s, indexes[start:end], write, record)
+			}
+		}(s, indexes)
+	}
+	wg.Wait()
+
+	for i, key := range keys {
+		if len(checked[i]) <= 0 {
+			continue
+		}
+
+		if counts[i] <= 0 {
+			errs[key] = ErrNotFoundServerNode
+			continue
+		}
+
+		if len(failures[i]) > 0 && counts[i]-len(failures[i]) < m.writeAck.required(counts[i]) {
+			errs[key] = failures[i][0]
+		}
+	}
+
+	return errs
+}
+
+func (m *MemcachedClient) execBatch(s *Server, indexes []int, writeReq func(req *bytebufferpool.ByteBuffer, i int, opaque uint32) error, record func(i int, err error)) {
+	req := bytebufferpool.Get()
+	defer bytebufferpool.Put(req)
+
+	sent := make([]int, 0, len(indexes))
+	for _, i := range indexes {
+		if err := writeReq(req, i, uint32(len(sent))); err != nil {
+			record(i, err)
+			continue
+		}
+		sent = append(sent, i)
+	}
+
+	if len(sent) <= 0 {
+		return
+	}
+
+	answered := make([]bool, len(sent))
+	err := m.sendQuietBatch(s, req, uint32(len(sent)), func(rsp *response) {
+		answered[rsp.opaque] = true
+		if resErr := rsp.statusErr(); resErr != nil {
+			record(sent[rsp.opaque], resErr)
+		}
+	})
+
+	for n, i := range sent {
+		if err != nil && !answered[n] {
+			record(i, err)
+		}
+	}
+}
+
+// sendQuietBatch sends req of `count` quiet requests to server.
+// Responses of quiet requests are only terminated by NOOP, so the connection can not be shared.
+func (m *MemcachedClient) sendQuietBatch(s *Server, req *bytebufferpool.ByteBuffer, count uint32, handle func(rsp *response)) error {
+	if err := m.enter(); err != nil {
+		return err
+	}
+	defer m.leave()
+
+	server, cmder, err := m.cluster.ChooseServerPooledCommander(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	return m.run(server, cmder, func(cmder *Commander) error {
+		return cmder.quietBatch(req, count, handle)
+	})
+}
@@ -0,0 +1,64 @@
+package gomemcached
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSetMultiAndDeleteMulti(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+
+	batchSize := MultiBatchSize
+	MultiBatchSize = 16
+	defer func() { MultiBatchSize = batchSize }()
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	// batches never share a pipelined connection
+	m.SetPipelining(1)
+
+	var items []*KeyArgs
+	for i := 0; i < 200; i++ {
+		items = append(items, &KeyArgs{Key: fmt.Sprintf("TestSetMulti%v", i), Value: i})
+	}
+	// CAS of a missing key fails
+	items = append(items, &KeyArgs{Key: "TestSetMultiCAS", Value: 0, CAS: 1})
+
+	errs := m.SetMulti(items)
+	if len(errs) != 1 || !errors.Is(errs["TestSetMultiCAS"], ErrKeyNotFound) {
+		t.Fatalf("SetMulti errs: %v", errs)
+	}
+
+	setq, noop := 0, 0
+	for _, s := range servers {
+		setq += s.OpCount(OPCODE_SETQ)
+		noop += s.OpCount(OPCODE_NOOP)
+	}
+	if setq != len(items) {
+		t.Errorf("servers received %v SETQ", setq)
+	}
+	if noop >= len(items)/8 {
+		t.Errorf("servers received %v NOOP", noop)
+	}
+
+	for i := 0; i < 200; i += 37 {
+		var value int
+		_, err := m.Get(fmt.Sprintf("TestSetMulti%v", i), &value)
+		if err != nil || value != i {
+			t.Fatalf("Get: %v, %v", value, err)
+		}
+	}
+
+	keys := []string{"TestSetMulti0", "TestSetMulti1", "TestDeleteMultiMissing"}
+	errs = m.DeleteMulti(keys)
+	if len(errs) != 1 || !errors.Is(errs["TestDeleteMultiMissing"], ErrKeyNotFound) {
+		t.Fatalf("DeleteMulti errs: %v", errs)
+	}
+
+	var value int
+	if _, err := m.Get("TestSetMulti0", &value); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Get deleted key: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package gomemcached
+
+import (
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How long the generation of a namespace is cached by the view,
+// so the invalidations made by other clients are visible after it at most.
+var NamespaceGenerationTTL = time.Duration(1) * time.Second
+
+const generationSuffix = "#gen"
+
+// Namespace is a view of Client which prefixes every key with the namespace and its generation,
+// the effective key is `prefix:generation:key`.
+// The generation is a counter stored in memcached, InvalidateNamespace increases it,
+// so the keys of previous generations are never read again and expire or are evicted in time.
+// The view shares the connections of client, closing the view closes the client.
+type Namespace struct {
+	Client
+	prefix  string
+	gen     uint64
+	expires time.Time
+	mu      sync.Mutex
+}
+
+func NewNamespace(client Client, prefix string) *Namespace {
+	return &Namespace{
+		Client: client,
+		prefix: prefix,
+	}
+}
+
+func (m *MemcachedClient) Namespace(prefix string) *Namespace {
+	return NewNamespace(m, prefix)
+}
+
+// Namespace returns a nested namespace of the view.
+func (ns *Namespace) Namespace(prefix string) *Namespace {
+	return NewNamespace(ns, prefix)
+}
+
+// InvalidateNamespace drops every key of the namespace by increasing its generation.
+func (ns *Namespace) InvalidateNamespace() error {
+	return ns.increaseGeneration(1)
+}
+
+func (ns *Namespace) generation() (uint64, error) {
+	ns.mu.Lock()
+	if time.Now().Before(ns.expires) {
+		gen := ns.gen
+		ns.mu.Unlock()
+		return gen, nil
+	}
+	ns.mu.Unlock()
+
+	if err := ns.increaseGeneration(0); err != nil {
+		return 0, err
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return ns.gen, nil
+}
+
+func (ns *Namespace) increaseGeneration(delta uint64) error {
+	// a counter which was evicted restarts from current time,
+	// so it does not go back to the generations in use before
+	gen, _, err := ns.Client.Increment(&KeyArgs{
+		Key:     ns.prefix + generationSuffix,
+		Delta:   delta,
+		Initial: uint64(time.Now().UnixNano()),
+	})
+	if err != nil {
+		return err
+	}
+
+	ns.mu.Lock()
+	ns.gen = gen
+	ns.expires = time.Now().Add(NamespaceGenerationTTL)
+	ns.mu.Unlock()
+	return nil
+}
+
+func (ns *Namespace) key(key string) (string, error) {
+	gen, err := ns.generation()
+	if err != nil {
+		return "", err
+	}
+
+	return ns.prefix + ":" + strconv.FormatUint(gen, 36) + ":" + key, nil
+}
+
+func (ns *Namespace) args(args *KeyArgs) (*KeyArgs, error) {
+	key, err := ns.key(args.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	nsArgs := *args
+	nsArgs.Key = key
+	return &nsArgs, nil
+}
+
+func (ns *Namespace) Get(key string, value interface{}) (uint64, error) {
+	key, err := ns.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.Get(key, value)
+}
+
+func (ns *Namespace) GetOrLoad(key string, dst interface{}, ttl uint32, loader func() (interface{}, error)) (uint64, error) {
+	key, err := ns.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.GetOrLoad(key, dst, ttl, loader)
+}
+
+func (ns *Namespace) GetAsync(key string, value interface{}) *Future {
+	key, err := ns.key(key)
+	if err != nil {
+		return newFailedFuture(err)
+	}
+	return ns.Client.GetAsync(key, value)
+}
+
+func (ns *Namespace) GetItem(key string) (*Item, error) {
+	nsKey, err := ns.key(key)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := ns.Client.GetItem(nsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	item.Key = key
+	return item, nil
+}
+
+func (ns *Namespace) SetItem(item *Item) (uint64, error) {
+	key, err := ns.key(item.Key)
+	if err != nil {
+		return 0, err
+	}
+
+	nsItem := *item
+	nsItem.Key = key
+	return ns.Client.SetItem(&nsItem)
+}
+
+func (ns *Namespace) GetTo(key string, w io.Writer) (uint64, error) {
+	key, err := ns.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.GetTo(key, w)
+}
+
+func (ns *Namespace) SetFrom(args *KeyArgs, r io.Reader, size int64) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.SetFrom(args, r, size)
+}
+
+func (ns *Namespace) Set(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.Set(args)
+}
+
+func (ns *Namespace) SetAsync(args *KeyArgs) *Future {
+	args, err := ns.args(args)
+	if err != nil {
+		return newFailedFuture(err)
+	}
+	return ns.Client.SetAsync(args)
+}
+
+func (ns *Namespace) Update(key string, dst interface{}, fn UpdateFunc) (int, error) {
+	key, err := ns.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.Update(key, dst, fn)
+}
+
+// SetWithTags prefixes `tags` as keys, so InvalidateNamespace resets them too.
+func (ns *Namespace) SetWithTags(args *KeyArgs, tags ...string) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+
+	tags, err = ns.tags(tags)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.SetWithTags(args, tags...)
+}
+
+func (ns *Namespace) InvalidateTags(tags ...string) error {
+	tags, err := ns.tags(tags)
+	if err != nil {
+		return err
+	}
+	return ns.Client.InvalidateTags(tags...)
+}
+
+func (ns *Namespace) tags(tags []string) ([]string, error) {
+	nsTags := make([]string, len(tags))
+	for i, tag := range tags {
+		var err error
+		if nsTags[i], err = ns.key(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	return nsTags, nil
+}
+
+func (ns *Namespace) SetRawData(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.SetRawData(args)
+}
+
+func (ns *Namespace) Add(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.Add(args)
+}
+
+func (ns *Namespace) AddRawData(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.AddRawData(args)
+}
+
+func (ns *Namespace) Replace(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.Replace(args)
+}
+
+func (ns *Namespace) ReplaceRawData(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.ReplaceRawData(args)
+}
+
+func (ns *Namespace) Delete(args *KeyArgs) error {
+	args, err := ns.args(args)
+	if err != nil {
+		return err
+	}
+	return ns.Client.Delete(args)
+}
+
+func (ns *Namespace) SetMulti(items []*KeyArgs) map[string]error {
+	keys := make(map[string]string, len(items))
+	nsItems := make([]*KeyArgs, 0, len(items))
+	errs := make(map[string]error)
+	for _, args := range items {
+		nsArgs, err := ns.args(args)
+		if err != nil {
+			errs[args.Key] = err
+			continue
+		}
+		keys[nsArgs.Key] = args.Key
+		nsItems = append(nsItems, nsArgs)
+	}
+
+	for key, err := range ns.Client.SetMulti(nsItems) {
+		errs[keys[key]] = err
+	}
+	return errs
+}
+
+func (ns *Namespace) DeleteMulti(keys []string) map[string]error {
+	origin := make(map[string]string, len(keys))
+	nsKeys := make([]string, 0, len(keys))
+	errs := make(map[string]error)
+	for _, key := range keys {
+		nsKey, err := ns.key(key)
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+		origin[nsKey] = key
+		nsKeys = append(nsKeys, nsKey)
+	}
+
+	for key, err := range ns.Client.DeleteMulti(nsKeys) {
+		errs[origin[key]] = err
+	}
+	return errs
+}
+
+func (ns *Namespace) NewBatch() *Batch {
+	b := ns.Client.NewBatch()
+	mapKey := b.mapKey
+	b.mapKey = func(key string) (string, error) {
+		key, err := ns.key(key)
+		if err != nil || mapKey == nil {
+			return key, err
+		}
+		return mapKey(key)
+	}
+	return b
+}
+
+func (ns *Namespace) Touch(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.Touch(args)
+}
+
+func (ns *Namespace) Append(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.Append(args)
+}
+
+func (ns *Namespace) Prepend(args *KeyArgs) (uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.Prepend(args)
+}
+
+func (ns *Namespace) Increment(args *KeyArgs) (uint64, uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ns.Client.Increment(args)
+}
+
+func (ns *Namespace) Decrement(args *KeyArgs) (uint64, uint64, error) {
+	args, err := ns.args(args)
+	if err != nil {
+		return 0, 0, err
+	}
+	return ns.Client.Decrement(args)
+}
+
+func (ns *Namespace) TouchAtomicValue(key string) (uint64, error) {
+	key, err := ns.key(key)
+	if err != nil {
+		return 0, err
+	}
+	return ns.Client.TouchAtomicValue(key)
+}
+
+// Flush invalidates the namespace instead of flushing the servers shared with other namespaces.
+func (ns *Namespace) Flush(args *KeyArgs) error {
+	return ns.InvalidateNamespace()
+}
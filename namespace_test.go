@@ -0,0 +1,70 @@
+package gomemcached
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	users := m.Namespace("users")
+	orders := m.Namespace("orders")
+	if _, err := users.Set(&KeyArgs{Key: "TestNamespace", Value: "user"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+	if _, err := orders.Set(&KeyArgs{Key: "TestNamespace", Value: "order"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	key, err := users.key("TestNamespace")
+	if err != nil {
+		t.Fatalf("key err: %v", err)
+	}
+	if !strings.HasPrefix(key, "users:") {
+		t.Errorf("key %v is not prefixed", key)
+	}
+	found := false
+	for _, s := range servers {
+		if _, _, ok := s.Value(key); ok {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("key %v is not stored", key)
+	}
+
+	var value string
+	if _, err := m.Get("TestNamespace", &value); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unprefixed Get: %v, %v", value, err)
+	}
+
+	results := users.NewBatch().Get("TestNamespace", &value).Execute()
+	if results[0].Err != nil || value != "user" {
+		t.Errorf("batch Get: %v, %v", value, results[0].Err)
+	}
+
+	if err := users.InvalidateNamespace(); err != nil {
+		t.Fatalf("InvalidateNamespace err: %v", err)
+	}
+	if _, err := users.Get("TestNamespace", &value); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get after invalidation: %v, %v", value, err)
+	}
+	if _, err := orders.Get("TestNamespace", &value); err != nil || value != "order" {
+		t.Errorf("other namespace: %v, %v", value, err)
+	}
+
+	// a new view reads the generation from server
+	other := m.Namespace("orders")
+	if err := orders.Flush(nil); err != nil {
+		t.Fatalf("Flush err: %v", err)
+	}
+	if _, err := other.Get("TestNamespace", &value); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get of other view after Flush: %v, %v", value, err)
+	}
+}
@@ -0,0 +1,185 @@
+package gomemcached
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Number of the invalidation epochs which keys are hashed into.
+const nearCacheEpochSlots = 256
+
+type NearCacheStats struct {
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+	Expirations uint64
+	Entries     int
+	Bytes       int64
+}
+
+type nearCacheEntry struct {
+	key      string
+	flag     uint32
+	value    []byte
+	cas      uint64
+	expireAt time.Time
+}
+
+func (e *nearCacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// nearCache is a bounded LRU cache of raw values in front of memcached.
+// Values are kept as raw bytes and decoded for every hit,
+// so the callers never share a decoded object.
+type nearCache struct {
+	maxEntries int
+	maxBytes   int64
+	ttl        time.Duration
+	ll         *list.List
+	items      map[string]*list.Element
+	bytes      int64
+	stats      NearCacheStats
+	// an invalidation moves the epoch of key on, so a value fetched before it is not cached
+	epochs [nearCacheEpochSlots]uint64
+	sync.Mutex
+}
+
+func newNearCache(maxEntries int, maxBytes int64, ttl time.Duration) *nearCache {
+	return &nearCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (nc *nearCache) get(key string) (uint32, []byte, uint64, bool) {
+	nc.Lock()
+	defer nc.Unlock()
+
+	elem, ok := nc.items[key]
+	if !ok {
+		nc.stats.Misses++
+		return 0, nil, 0, false
+	}
+
+	entry := elem.Value.(*nearCacheEntry)
+	if !time.Now().Before(entry.expireAt) {
+		nc.removeElement(elem)
+		nc.stats.Expirations++
+		nc.stats.Misses++
+		return 0, nil, 0, false
+	}
+
+	nc.ll.MoveToFront(elem)
+	nc.stats.Hits++
+	return entry.flag, entry.value, entry.cas, true
+}
+
+// epoch returns the invalidation epoch of key, it is taken before value is fetched for put.
+func (nc *nearCache) epoch(key string) uint64 {
+	nc.Lock()
+	defer nc.Unlock()
+
+	return nc.epochs[MakeHash(key)%nearCacheEpochSlots]
+}
+
+// put stores value if key has not been invalidated since `epoch`,
+// value must not be modified by caller after put.
+func (nc *nearCache) put(key string, epoch uint64, flag uint32, value []byte, cas uint64) {
+	entry := &nearCacheEntry{
+		key:      key,
+		flag:     flag,
+		value:    value,
+		cas:      cas,
+		expireAt: time.Now().Add(nc.ttl),
+	}
+
+	// the value is too large to be cached at all
+	if nc.maxBytes > 0 && entry.size() > nc.maxBytes {
+		return
+	}
+
+	nc.Lock()
+	defer nc.Unlock()
+
+	if nc.epochs[MakeHash(key)%nearCacheEpochSlots] != epoch {
+		return
+	}
+
+	if elem, ok := nc.items[key]; ok {
+		nc.removeElement(elem)
+	}
+
+	nc.items[key] = nc.ll.PushFront(entry)
+	nc.bytes += entry.size()
+
+	for (nc.maxEntries > 0 && nc.ll.Len() > nc.maxEntries) || (nc.maxBytes > 0 && nc.bytes > nc.maxBytes) {
+		nc.removeElement(nc.ll.Back())
+		nc.stats.Evictions++
+	}
+}
+
+func (nc *nearCache) remove(key string) {
+	nc.Lock()
+	defer nc.Unlock()
+
+	nc.epochs[MakeHash(key)%nearCacheEpochSlots]++
+	if elem, ok := nc.items[key]; ok {
+		nc.removeElement(elem)
+	}
+}
+
+func (nc *nearCache) clear() {
+	nc.Lock()
+	defer nc.Unlock()
+
+	nc.ll.Init()
+	nc.items = make(map[string]*list.Element)
+	nc.bytes = 0
+	for i := range nc.epochs {
+		nc.epochs[i]++
+	}
+}
+
+func (nc *nearCache) removeElement(elem *list.Element) {
+	entry := nc.ll.Remove(elem).(*nearCacheEntry)
+	delete(nc.items, entry.key)
+	nc.bytes -= entry.size()
+}
+
+func (nc *nearCache) getStats() NearCacheStats {
+	nc.Lock()
+	defer nc.Unlock()
+
+	stats := nc.stats
+	stats.Entries = nc.ll.Len()
+	stats.Bytes = nc.bytes
+	return stats
+}
+
+func (m *MemcachedClient) SetNearCache(maxEntries int, maxBytes int64, ttl time.Duration) {
+	if ttl <= 0 || (maxEntries <= 0 && maxBytes <= 0) {
+		m.nearCache = nil
+		return
+	}
+
+	m.nearCache = newNearCache(maxEntries, maxBytes, ttl)
+}
+
+func (m *MemcachedClient) NearCacheStats() NearCacheStats {
+	if m.nearCache == nil {
+		return NearCacheStats{}
+	}
+
+	return m.nearCache.getStats()
+}
+
+func (m *MemcachedClient) invalidateNearCache(key string) {
+	if m.nearCache != nil {
+		m.nearCache.remove(key)
+	}
+}
@@ -0,0 +1,108 @@
+package gomemcached
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNearCacheBounds(t *testing.T) {
+	nc := newNearCache(2, 0, time.Minute)
+	nc.put("a", 0, 0, []byte("1"), 1)
+	nc.put("b", 0, 0, []byte("2"), 2)
+	nc.get("a")
+	nc.put("c", 0, 0, []byte("3"), 3)
+
+	if _, _, _, ok := nc.get("b"); ok {
+		t.Errorf("least recently used entry should be evicted")
+	}
+	if _, _, _, ok := nc.get("a"); !ok {
+		t.Errorf("recently used entry should be kept")
+	}
+
+	nc = newNearCache(0, 10, time.Minute)
+	nc.put("a", 0, 0, []byte("1234"), 1)
+	nc.put("b", 0, 0, []byte("1234"), 2)
+	nc.put("c", 0, 0, []byte("123456789012"), 3)
+	nc.put("d", 0, 0, []byte("1234"), 4)
+	if _, _, _, ok := nc.get("c"); ok {
+		t.Errorf("entry larger than maxBytes should not be cached")
+	}
+	if stats := nc.getStats(); stats.Entries != 2 || stats.Bytes != 10 || stats.Evictions != 1 {
+		t.Errorf("stats: %+v", stats)
+	}
+
+	nc = newNearCache(10, 0, time.Millisecond)
+	nc.put("a", 0, 0, []byte("1"), 1)
+	time.Sleep(time.Millisecond * 5)
+	if _, _, _, ok := nc.get("a"); ok {
+		t.Errorf("expired entry should not be returned")
+	}
+	if stats := nc.getStats(); stats.Expirations != 1 || stats.Misses != 1 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
+
+func TestNearCacheEpoch(t *testing.T) {
+	nc := newNearCache(10, 0, time.Minute)
+
+	// a write invalidates key while the old value is fetched
+	epoch := nc.epoch("a")
+	nc.remove("a")
+	nc.put("a", epoch, 0, []byte("stale"), 1)
+	if _, _, _, ok := nc.get("a"); ok {
+		t.Errorf("value fetched before invalidation should not be cached")
+	}
+
+	epoch = nc.epoch("a")
+	nc.clear()
+	nc.put("a", epoch, 0, []byte("stale"), 1)
+	if _, _, _, ok := nc.get("a"); ok {
+		t.Errorf("value fetched before clear should not be cached")
+	}
+
+	nc.put("a", nc.epoch("a"), 0, []byte("fresh"), 2)
+	if _, value, _, ok := nc.get("a"); !ok || string(value) != "fresh" {
+		t.Errorf("value is not cached: %s, %v", value, ok)
+	}
+}
+
+func TestNearCache(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetNearCache(100, 0, time.Minute)
+
+	_, err := m.Set(&KeyArgs{Key: "TestNearCache", Value: &Person{Name: "lennon", Age: 29}})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		var p Person
+		_, err = m.Get("TestNearCache", &p)
+		if err != nil || p.Name != "lennon" {
+			t.Fatalf("Get: %v, %v", p, err)
+		}
+	}
+
+	if n := servers[0].OpCount(OPCODE_GET); n != 1 {
+		t.Errorf("server received %v gets", n)
+	}
+
+	_, err = m.Set(&KeyArgs{Key: "TestNearCache", Value: &Person{Name: "yoko", Age: 33}})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	var p Person
+	_, err = m.Get("TestNearCache", &p)
+	if err != nil || p.Name != "yoko" {
+		t.Fatalf("Get after Set: %v, %v", p, err)
+	}
+
+	if stats := m.NearCacheStats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
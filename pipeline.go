@@ -0,0 +1,401 @@
+package gomemcached
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+var (
+	// PipelineQueueSize is the number of requests which can be queued to the writer of a pipelined connection.
+	PipelineQueueSize = 128
+	// PipelineMaxTimeouts is the number of requests timing out in a row which break a pipelined connection.
+	PipelineMaxTimeouts = 3
+)
+
+// pipeline multiplexes the requests of many callers on one connection.
+// The writer goroutine batches queued requests into one flush,
+// the reader goroutine dispatches responses to callers by the opaque field of header.
+type pipeline struct {
+	cmder   *Commander
+	calls   chan *pipeCall
+	quit    chan struct{}
+	opaque  uint32
+	pending map[uint32]*pipeCall
+	err     error
+	// requests timed out since the last response
+	timeouts int
+	mu       sync.Mutex
+}
+
+type pipeCall struct {
+	req  []byte
+	done chan pipeResult
+}
+
+type pipeResult struct {
+	rsp response
+	err error
+}
+
+type pipeTimeoutError struct{}
+
+func (pipeTimeoutError) Error() string   { return "Pipelined request timeout" }
+func (pipeTimeoutError) Timeout() bool   { return true }
+func (pipeTimeoutError) Temporary() bool { return true }
+
+var _ net.Error = pipeTimeoutError{}
+
+func newPipeCommander(conn net.Conn, s *Server) *Commander {
+	cmder := newCommander(atomic.AddInt64(&CommanderID, 1), conn, s)
+	p := &pipeline{
+		cmder:   cmder,
+		calls:   make(chan *pipeCall, PipelineQueueSize),
+		quit:    make(chan struct{}),
+		pending: make(map[uint32]*pipeCall),
+	}
+	cmder.pipe = p
+
+	go p.writeLoop()
+	go p.readLoop()
+	return cmder
+}
+
+// roundTrip sends req and waits for its response at most ReadTimeout or until cancel is closed.
+// A request which timed out or was cancelled does not break the connection, its late response is dropped,
+// but the connection is broken when PipelineMaxTimeouts requests time out in a row.
+func (p *pipeline) roundTrip(req *bytebufferpool.ByteBuffer, cancel <-chan struct{}) (*bytebufferpool.ByteBuffer, uint8, uint64, error) {
+	call := &pipeCall{
+		req:  make([]byte, len(req.B)),
+		done: make(chan pipeResult, 1),
+	}
+	copy(call.req, req.B)
+
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return nil, 0, 0, p.err
+	}
+	p.opaque++
+	opaque := p.opaque
+	p.pending[opaque] = call
+	p.mu.Unlock()
+
+	binary.BigEndian.PutUint32(call.req[12:16], opaque)
+
+	timer := time.NewTimer(ReadTimeout)
+	defer timer.Stop()
+
+	var res pipeResult
+	select {
+	case p.calls <- call:
+	case <-p.quit:
+		// the pending calls were failed when pipeline quit
+	}
+
+	var dropErr error
+	timedOut := false
+	select {
+	case res = <-call.done:
+	case <-timer.C:
+		dropErr = pipeTimeoutError{}
+		timedOut = true
+	case <-cancel:
+		dropErr = errHedgeCancelled
+	}
+
+	if dropErr != nil {
+		p.mu.Lock()
+		_, ok := p.pending[opaque]
+		delete(p.pending, opaque)
+		stalled := false
+		if ok && timedOut {
+			p.timeouts++
+			stalled = p.timeouts >= PipelineMaxTimeouts
+		}
+		p.mu.Unlock()
+
+		if stalled {
+			p.stall()
+		}
+		if ok {
+			return nil, 0, 0, dropErr
+		}
+		// the response has been dispatched meanwhile
+		res = <-call.done
+	}
+
+	if res.err != nil {
+		return nil, 0, 0, res.err
+	}
+
+	if err := res.rsp.statusErr(); err != nil {
+		bytebufferpool.Put(res.rsp.body)
+		return nil, 0, 0, err
+	}
+
+	return res.rsp.body, res.rsp.extLen, res.rsp.cas, nil
+}
+
+func (p *pipeline) writeLoop() {
+	for {
+		var call *pipeCall
+		select {
+		case call = <-p.calls:
+		case <-p.quit:
+			return
+		}
+
+		p.cmder.conn.SetWriteDeadline(time.Now().Add(WriterTimeout))
+		_, err := p.cmder.rw.Write(call.req)
+		// batch the requests queued meanwhile into one flush
+		for n := len(p.calls); err == nil && n > 0; n-- {
+			call = <-p.calls
+			_, err = p.cmder.rw.Write(call.req)
+		}
+
+		if err == nil {
+			err = p.cmder.rw.Flush()
+		}
+
+		if err != nil {
+			p.broke(err)
+			return
+		}
+	}
+}
+
+func (p *pipeline) readLoop() {
+	for {
+		rsp, err := p.cmder.readResponse()
+		if err != nil {
+			p.broke(err)
+			return
+		}
+
+		p.mu.Lock()
+		p.timeouts = 0
+		call, ok := p.pending[rsp.opaque]
+		delete(p.pending, rsp.opaque)
+		p.mu.Unlock()
+
+		if !ok {
+			// caller has given up waiting
+			bytebufferpool.Put(rsp.body)
+			continue
+		}
+
+		call.done <- pipeResult{rsp: rsp}
+	}
+}
+
+// broke fails the pipeline broken by err, and reports it to the server,
+// the pipeline which has been closed is not reported.
+func (p *pipeline) broke(err error) {
+	if p.fail(err) {
+		p.cmder.server.cluster.pipeBroken(p.cmder.server)
+	}
+}
+
+// stall breaks the pipeline whose peer stopped answering,
+// it is reported to the server checker the same as a pooled connection which was given up.
+func (p *pipeline) stall() {
+	if p.fail(pipeTimeoutError{}) {
+		p.cmder.server.cluster.pipeStalled(p.cmder.server, p.cmder)
+	}
+}
+
+// fail closes the connection and fails every pending request with err,
+// it returns false if the pipeline has failed before.
+func (p *pipeline) fail(err error) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return false
+	}
+
+	p.err = err
+	close(p.quit)
+	p.cmder.conn.Close()
+	for opaque, call := range p.pending {
+		call.done <- pipeResult{err: err}
+		delete(p.pending, opaque)
+	}
+	return true
+}
+
+func (p *pipeline) broken() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.err != nil
+}
+
+func (p *pipeline) close() {
+	p.fail(ErrNotConnected)
+}
+
+// getPipeCmder returns a handle of the next usable pipelined connection in turn.
+// Connections are never dialed here because the cluster is locked,
+// ErrNoUsableConnection is returned while the broken connections are dialed in background.
+func (s *Server) getPipeCmder() (*Commander, error) {
+	for n := 0; n < len(s.pipes); n++ {
+		i := s.nextPipe % len(s.pipes)
+		s.nextPipe++
+
+		pipeCmder := s.pipes[i]
+		if pipeCmder == nil || pipeCmder.pipe.broken() {
+			continue
+		}
+
+		// connection is shared, every caller gets its own handle
+		return &Commander{
+			ID:     pipeCmder.ID,
+			conn:   pipeCmder.conn,
+			server: s,
+			pipe:   pipeCmder.pipe,
+		}, nil
+	}
+
+	s.cluster.dialPipes(s)
+	return nil, ErrNoUsableConnection
+}
+
+// dialPipes starts dialing the broken pipelined connections of server in background,
+// the cluster must be locked.
+func (cl *Cluster) dialPipes(s *Server) {
+	if s.pipeDialing {
+		return
+	}
+
+	s.pipeDialing = true
+	go cl.redialPipes(s)
+}
+
+// pipeBroken is called when a pipelined connection of server is broken,
+// it is dialed again at once, so a server which refuses connections is found without waiting for requests.
+func (cl *Cluster) pipeBroken(s *Server) {
+	cl.Lock()
+	defer cl.Unlock()
+
+	if !cl.closed && cl.addr2Servers[s.Addr] == s && !s.dead {
+		cl.dialPipes(s)
+	}
+}
+
+// pipeStalled records the stalled pipelined connection of server as bad and notices the server checker,
+// the connection is dialed again meanwhile.
+func (cl *Cluster) pipeStalled(s *Server, cmder *Commander) {
+	cl.Lock()
+	if cl.closed || cl.addr2Servers[s.Addr] != s || s.dead {
+		cl.Unlock()
+		return
+	}
+	s.badCmders = append(s.badCmders, cmder)
+	cl.dialPipes(s)
+	cl.Unlock()
+
+	select {
+	case cl.badServerNoticer <- s:
+	case <-cl.ctx.Done():
+	}
+}
+
+// redialPipes dials the broken pipelined connections of server one by one outside the cluster lock.
+// When the server refuses a connection, it is reported to the server checker as unreachable,
+// the same as a server whose pooled connections are all bad.
+func (cl *Cluster) redialPipes(s *Server) {
+	for {
+		cl.Lock()
+		i := -1
+		if !cl.closed && cl.addr2Servers[s.Addr] == s && !s.dead {
+			for n, pipeCmder := range s.pipes {
+				if pipeCmder == nil || pipeCmder.pipe.broken() {
+					i = n
+					break
+				}
+			}
+		}
+		if i < 0 {
+			s.pipeDialing = false
+			cl.Unlock()
+			return
+		}
+		cl.Unlock()
+
+		conn, err := connect(s.Addr)
+
+		cl.Lock()
+		if err != nil {
+			s.pipeDialing = false
+			s.unreachable = true
+			cl.Unlock()
+
+			select {
+			case cl.badServerNoticer <- s:
+			case <-cl.ctx.Done():
+			}
+			return
+		}
+
+		if cl.closed || s.removed || i >= len(s.pipes) || (s.pipes[i] != nil && !s.pipes[i].pipe.broken()) {
+			conn.Close()
+		} else {
+			s.pipes[i] = newPipeCommander(conn, s)
+		}
+		cl.Unlock()
+	}
+}
+
+func (s *Server) resetPipes(connsPerServer int) {
+	s.closePipes()
+	if connsPerServer > 0 {
+		s.pipes = make([]*Commander, connsPerServer)
+	} else {
+		s.pipes = nil
+	}
+}
+
+func (s *Server) closePipes() {
+	for i, cmder := range s.pipes {
+		if cmder != nil {
+			cmder.pipe.close()
+		}
+		s.pipes[i] = nil
+	}
+}
+
+// setPipelining dials the pipelined connections of every server before it returns,
+// the cluster is not locked while dialing.
+func (cl *Cluster) setPipelining(connsPerServer int) {
+	cl.Lock()
+	cl.pipeConns = connsPerServer
+	var servers []*Server
+	for _, s := range cl.addr2Servers {
+		s.resetPipes(connsPerServer)
+		if connsPerServer > 0 && !s.pipeDialing {
+			s.pipeDialing = true
+			servers = append(servers, s)
+		}
+	}
+	cl.Unlock()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *Server) {
+			defer wg.Done()
+			cl.redialPipes(s)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func (m *MemcachedClient) SetPipelining(connsPerServer int) {
+	m.cluster.setPipelining(connsPerServer)
+}
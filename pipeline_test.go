@@ -0,0 +1,174 @@
+package gomemcached
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelining(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 1).(*MemcachedClient)
+	defer m.Exit()
+	m.SetPipelining(2)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				key := fmt.Sprintf("TestPipelining%v", i)
+				want := fmt.Sprintf("value-%v-%v", i, n)
+				if _, err := m.Set(&KeyArgs{Key: key, Value: want}); err != nil {
+					errs <- err
+					return
+				}
+
+				var value string
+				if _, err := m.Get(key, &value); err != nil || value != want {
+					errs <- fmt.Errorf("Get %v: %v, %v", key, value, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// one pooled connection and two pipelined connections
+	if n := servers[0].ConnCount(); n != 3 {
+		t.Errorf("server has %v connections", n)
+	}
+}
+
+func TestPipeliningTimeout(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	readTimeout := ReadTimeout
+	ReadTimeout = time.Millisecond * 100
+	defer func() { ReadTimeout = readTimeout }()
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 1).(*MemcachedClient)
+	defer m.Exit()
+	m.SetPipelining(1)
+
+	_, err := m.Set(&KeyArgs{Key: "TestPipeliningTimeout", Value: "HelloWorld"})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	servers[0].SetDelay(time.Millisecond * 300)
+	var value string
+	_, err = m.Get("TestPipeliningTimeout", &value)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Get should time out: %v", err)
+	}
+	servers[0].SetDelay(0)
+
+	// the late response is dropped and the connection is still usable
+	time.Sleep(time.Millisecond * 300)
+	_, err = m.Set(&KeyArgs{Key: "TestPipeliningTimeout", Value: "HelloAgain"})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+	_, err = m.Get("TestPipeliningTimeout", &value)
+	if err != nil || value != "HelloAgain" {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+
+	if n := servers[0].ConnCount(); n != 2 {
+		t.Errorf("server has %v connections", n)
+	}
+}
+
+func benchmarkGet(b *testing.B, pipeConns int) {
+	servers := startFakeServers(b, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 4).(*MemcachedClient)
+	defer m.Exit()
+	m.SetPipelining(pipeConns)
+
+	if _, err := m.SetRawData(&KeyArgs{Key: "BenchmarkGet", Value: []byte("HelloWorld")}); err != nil {
+		b.Fatalf("Set err: %v", err)
+	}
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			var value []byte
+			_, err := m.Get("BenchmarkGet", &value)
+			// the pool is exhausted when callers outnumber connections, wait for a connection
+			for errors.Is(err, ErrNoUsableConnection) {
+				runtime.Gosched()
+				_, err = m.Get("BenchmarkGet", &value)
+			}
+			if err != nil {
+				b.Errorf("Get err: %v", err)
+				return
+			}
+		}
+	})
+}
+
+// Both benchmarks share 4 connections among 16 callers per CPU.
+func BenchmarkGetPooled(b *testing.B) {
+	benchmarkGet(b, 0)
+}
+
+func BenchmarkGetPipelined(b *testing.B) {
+	benchmarkGet(b, 4)
+}
+
+func TestPipeliningServerDown(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	m, primary := startFailureClient(servers, "TestPipelining")
+	defer m.Exit()
+	m.SetPipelining(2)
+	m.SetFailurePolicy(FailureFailFast, 0)
+
+	// the broken pipelined connections are dialed again and refused,
+	// so the server is found dead before the heartbeat checks the pooled connection
+	killServer(t, m, primary, "TestPipelining")
+}
+
+func TestPipeliningStalled(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	readTimeout := ReadTimeout
+	ReadTimeout = time.Millisecond * 50
+	defer func() { ReadTimeout = readTimeout }()
+
+	m, primary := startFailureClient(servers, "TestPipelining")
+	defer m.Exit()
+	m.SetPipelining(1)
+	m.SetFailurePolicy(FailureFailFast, 0)
+
+	// the server accepts connections but stops answering,
+	// the stalled pipelined connection reports it long before the heartbeat
+	primary.SetDelay(time.Second)
+	defer primary.SetDelay(0)
+	waitFor(t, "server dead", func() bool {
+		var value string
+		_, err := m.Get("TestPipelining", &value)
+		return errors.Is(err, ErrServerUnavailable)
+	})
+}
@@ -1,5 +1,7 @@
 package gomemcached
 
+import "strconv"
+
 const (
 	MAGIC_REQUEST  uint8 = 0x80
 	MAGIC_RESPONSE uint8 = 0x81
@@ -34,16 +36,56 @@ const (
 	OPCODE_DECR    uint8 = 0x06
 	OPCODE_QUIT    uint8 = 0x07
 	OPCODE_FLUSH   uint8 = 0x08
+	OPCODE_GETQ    uint8 = 0x09
 	OPCODE_NOOP    uint8 = 0x0a
 	OPCODE_VERSION uint8 = 0x0b
 	OPCODE_GETK    uint8 = 0x0c
 	OPCODE_APPEND  uint8 = 0x0e
 	OPCODE_PREPEND uint8 = 0x0f
 	OPCODE_STAT    uint8 = 0x10
+	OPCODE_SETQ    uint8 = 0x11
+	OPCODE_ADDQ    uint8 = 0x12
+	OPCODE_DELQ    uint8 = 0x14
+	OPCODE_APPENDQ uint8 = 0x19
+	OPCODE_TOUCH   uint8 = 0x1c
 )
 
+var opNames = map[uint8]string{
+	OPCODE_GET:     "get",
+	OPCODE_SET:     "set",
+	OPCODE_ADD:     "add",
+	OPCODE_REPLACE: "replace",
+	OPCODE_DEL:     "delete",
+	OPCODE_INCR:    "increment",
+	OPCODE_DECR:    "decrement",
+	OPCODE_QUIT:    "quit",
+	OPCODE_FLUSH:   "flush",
+	OPCODE_GETQ:    "getq",
+	OPCODE_NOOP:    "noop",
+	OPCODE_VERSION: "version",
+	OPCODE_GETK:    "getk",
+	OPCODE_APPEND:  "append",
+	OPCODE_PREPEND: "prepend",
+	OPCODE_STAT:    "stat",
+	OPCODE_SETQ:    "setq",
+	OPCODE_ADDQ:    "addq",
+	OPCODE_DELQ:    "deleteq",
+	OPCODE_APPENDQ: "appendq",
+	OPCODE_TOUCH:   "touch",
+}
+
+func opName(opCode uint8) string {
+	if name, ok := opNames[opCode]; ok {
+		return name
+	}
+	return "opcode 0x" + strconv.FormatUint(uint64(opCode), 16)
+}
+
+// Flags reserved by this client, the values stored with other flags are raw bytes.
 const (
 	USE_MSGP_FLAG uint32 = 0x01
+	// Envelope of SetWithTags, it is "TAG\x01" so it hardly collides with the small flags of other clients.
+	USE_TAGGED_FLAG uint32 = 0x54414701
 )
 
 const (
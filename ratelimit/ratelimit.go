@@ -0,0 +1,155 @@
+// Package ratelimit implements rate limiters on memcached counters.
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/shaoyuan1943/gomemcached"
+)
+
+// FailurePolicy decides whether requests are allowed when the counter can not be read or updated,
+// usually because the server owning the key is unavailable.
+type FailurePolicy int
+
+const (
+	FailOpen FailurePolicy = iota
+	FailClosed
+)
+
+const keyPrefix = "ratelimit:"
+
+type Limiter interface {
+	// Allow counts a request of key and reports whether it is allowed,
+	// how many requests remain in current window and when the window resets.
+	Allow(key string) (bool, int64, time.Time)
+}
+
+// FixedWindow allows `limit` requests in every window of `window` length.
+type FixedWindow struct {
+	client gomemcached.Client
+	limit  int64
+	window time.Duration
+	policy FailurePolicy
+	// Prefix of counter keys.
+	Prefix string
+	now    func() time.Time
+}
+
+func NewFixedWindow(client gomemcached.Client, limit int64, window time.Duration, policy FailurePolicy) *FixedWindow {
+	return &FixedWindow{
+		client: client,
+		limit:  limit,
+		window: window,
+		policy: policy,
+		Prefix: keyPrefix,
+		now:    time.Now,
+	}
+}
+
+func (l *FixedWindow) Allow(key string) (bool, int64, time.Time) {
+	start := l.now().Truncate(l.window)
+	resetAt := start.Add(l.window)
+
+	count, err := increase(l.client, bucketKey(l.Prefix, key, start, l.window), l.window)
+	if err != nil {
+		return l.fail(resetAt)
+	}
+
+	return count <= l.limit, remaining(l.limit, count), resetAt
+}
+
+func (l *FixedWindow) fail(resetAt time.Time) (bool, int64, time.Time) {
+	if l.policy == FailOpen {
+		return true, l.limit, resetAt
+	}
+	return false, 0, resetAt
+}
+
+// SlidingWindow approximates a sliding window with two fixed buckets,
+// the count of previous bucket is weighted by how much of it overlaps the sliding window.
+type SlidingWindow struct {
+	client gomemcached.Client
+	limit  int64
+	window time.Duration
+	policy FailurePolicy
+	// Prefix of counter keys.
+	Prefix string
+	now    func() time.Time
+}
+
+func NewSlidingWindow(client gomemcached.Client, limit int64, window time.Duration, policy FailurePolicy) *SlidingWindow {
+	return &SlidingWindow{
+		client: client,
+		limit:  limit,
+		window: window,
+		policy: policy,
+		Prefix: keyPrefix,
+		now:    time.Now,
+	}
+}
+
+func (l *SlidingWindow) Allow(key string) (bool, int64, time.Time) {
+	now := l.now()
+	start := now.Truncate(l.window)
+	resetAt := start.Add(l.window)
+
+	// the current bucket must live until it becomes the previous bucket of next window
+	count, err := increase(l.client, bucketKey(l.Prefix, key, start, l.window), l.window*2)
+	if err != nil {
+		return l.fail(resetAt)
+	}
+
+	prevCount, err := l.client.TouchAtomicValue(bucketKey(l.Prefix, key, start.Add(-l.window), l.window))
+	if errors.Is(err, gomemcached.ErrKeyNotFound) {
+		prevCount, err = 0, nil
+	}
+	if err != nil {
+		return l.fail(resetAt)
+	}
+
+	weight := 1 - float64(now.Sub(start))/float64(l.window)
+	estimated := int64(float64(prevCount)*weight) + count
+
+	return estimated <= l.limit, remaining(l.limit, estimated), resetAt
+}
+
+func (l *SlidingWindow) fail(resetAt time.Time) (bool, int64, time.Time) {
+	if l.policy == FailOpen {
+		return true, l.limit, resetAt
+	}
+	return false, 0, resetAt
+}
+
+// increase adds 1 to counter, the counter is created with 1 when it does not exist.
+func increase(client gomemcached.Client, key string, ttl time.Duration) (int64, error) {
+	count, _, err := client.Increment(&gomemcached.KeyArgs{
+		Key:        key,
+		Delta:      1,
+		Initial:    1,
+		Expiration: expiration(ttl),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(count), nil
+}
+
+func bucketKey(prefix, key string, start time.Time, window time.Duration) string {
+	return prefix + key + ":" + strconv.FormatInt(start.UnixNano()/int64(window), 10)
+}
+
+// expiration rounds ttl up to seconds and keeps one more second,
+// so a bucket never expires before its window ends.
+func expiration(ttl time.Duration) uint32 {
+	return uint32((ttl+time.Second-1)/time.Second) + 1
+}
+
+func remaining(limit, count int64) int64 {
+	if count >= limit {
+		return 0
+	}
+	return limit - count
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shaoyuan1943/gomemcached"
+	"github.com/shaoyuan1943/gomemcached/internal/fakeserver"
+)
+
+func newClient(t *testing.T) (gomemcached.Client, *fakeserver.Server) {
+	s, err := fakeserver.Start()
+	if err != nil {
+		t.Fatalf("start fake server err: %v", err)
+	}
+
+	return gomemcached.NewMemcachedClient([]string{s.Addr()}, 2), s
+}
+
+func TestFixedWindow(t *testing.T) {
+	client, s := newClient(t)
+	defer s.Close()
+	defer client.Exit()
+
+	now := time.Unix(1599999960, 0)
+	l := NewFixedWindow(client, 3, time.Minute, FailClosed)
+	l.now = func() time.Time { return now }
+
+	for i := int64(1); i <= 3; i++ {
+		allowed, left, resetAt := l.Allow("user1")
+		if !allowed || left != 3-i || !resetAt.Equal(now.Add(time.Minute)) {
+			t.Fatalf("request %v: %v, %v, %v", i, allowed, left, resetAt)
+		}
+	}
+
+	if allowed, left, _ := l.Allow("user1"); allowed || left != 0 {
+		t.Fatalf("request over limit: %v, %v", allowed, left)
+	}
+
+	if allowed, _, _ := l.Allow("user2"); !allowed {
+		t.Fatalf("keys share counter")
+	}
+
+	now = now.Add(time.Minute)
+	if allowed, left, _ := l.Allow("user1"); !allowed || left != 2 {
+		t.Fatalf("request in next window: %v, %v", allowed, left)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	client, s := newClient(t)
+	defer s.Close()
+	defer client.Exit()
+
+	now := time.Unix(1599999960, 0)
+	l := NewSlidingWindow(client, 4, time.Minute, FailClosed)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		if allowed, _, _ := l.Allow("user1"); !allowed {
+			t.Fatalf("request %v is not allowed", i)
+		}
+	}
+
+	// a quarter into next window, 3/4 of previous count still weights
+	now = now.Add(time.Minute + time.Second*15)
+	if allowed, left, _ := l.Allow("user1"); !allowed || left != 0 {
+		t.Fatalf("request: %v, %v", allowed, left)
+	}
+	if allowed, _, _ := l.Allow("user1"); allowed {
+		t.Fatalf("request over estimated limit is allowed")
+	}
+
+	now = now.Add(time.Second * 40)
+	if allowed, _, _ := l.Allow("user1"); !allowed {
+		t.Fatalf("request is not allowed after previous bucket decays")
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	client, s := newClient(t)
+	defer client.Exit()
+	s.Close()
+
+	if allowed, _, _ := NewFixedWindow(client, 3, time.Minute, FailOpen).Allow("user1"); !allowed {
+		t.Errorf("fail open limiter denies request")
+	}
+
+	if allowed, _, _ := NewSlidingWindow(client, 3, time.Minute, FailClosed).Allow("user1"); allowed {
+		t.Errorf("fail closed limiter allows request")
+	}
+}
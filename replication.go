@@ -0,0 +1,173 @@
+package gomemcached
+
+import (
+	"errors"
+	"sync"
+)
+
+// WriteAck decides how many servers must acknowledge a replicated write.
+type WriteAck int
+
+const (
+	// The write is successful when any server stored it.
+	WriteAckOne WriteAck = iota
+	// The write is successful when the majority of servers stored it.
+	WriteAckQuorum
+	// The write is successful only when every server stored it.
+	WriteAckAll
+)
+
+func (ack WriteAck) required(count int) int {
+	switch ack {
+	case WriteAckAll:
+		return count
+	case WriteAckQuorum:
+		return count/2 + 1
+	default:
+		return 1
+	}
+}
+
+// PartialWritePolicy decides what to do with the copies when a replicated write failed on some servers.
+type PartialWritePolicy int
+
+const (
+	// Keep every copy as it is.
+	PartialWriteKeep PartialWritePolicy = iota
+	// Delete the key from the servers which failed the write,
+	// so that they can not serve stale data to the fallback reads.
+	PartialWriteInvalidate
+	// When the write is not acknowledged by enough servers,
+	// delete the key from the servers which succeeded too.
+	PartialWriteRollback
+)
+
+func (m *MemcachedClient) SetReplication(factor int, ack WriteAck, policy PartialWritePolicy) {
+	m.replicas = factor
+	m.writeAck = ack
+	m.partialWritePolicy = policy
+}
+
+// execReplicasRead tries primary server first then the replicas on miss or error,
+// the error of primary server is returned when every server failed.
+func (m *MemcachedClient) execReplicasRead(opCode uint8, key string, cmdFunc func(cmder *Commander) error) error {
+	servers := m.cluster.ChooseServersByKey(key, m.replicas)
+	if len(servers) <= 0 {
+		return ErrNotFoundServerNode
+	}
+
+	var firstErr error
+	for _, s := range servers {
+		err := m.execServer(opCode, key, s.Addr, cmdFunc)
+		if err == nil {
+			return nil
+		}
+
+		// the failure caused by caller is not a failure of server
+		var callerErr *callerError
+		if errors.As(err, &callerErr) {
+			return err
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// execReplicasWrite writes key to primary server and replicas.
+// When the write is conditional(Add/Replace/CAS), primary server is written first,
+// the replicas are written only if primary server succeeded.
+// Otherwise all servers are written concurrently.
+func (m *MemcachedClient) execReplicasWrite(opCode uint8, key string, conditional bool, cas uint64, cmdFunc func(cmder *Commander, primary bool) error) error {
+	servers := m.cluster.ChooseServersByKey(key, m.replicas)
+	if len(servers) <= 0 {
+		return ErrNotFoundServerNode
+	}
+
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	write := func(i int) {
+		defer wg.Done()
+		// only primary server writes with CAS
+		guard := uint64(0)
+		if i == 0 {
+			guard = cas
+		}
+		errs[i] = m.execServerCAS(opCode, key, servers[i].Addr, guard, func(cmder *Commander) error {
+			return cmdFunc(cmder, i == 0)
+		})
+	}
+
+	start := 0
+	if conditional {
+		wg.Add(1)
+		write(0)
+		if errs[0] != nil {
+			return errs[0]
+		}
+		start = 1
+	}
+
+	for i := start; i < len(servers); i++ {
+		wg.Add(1)
+		go write(i)
+	}
+	wg.Wait()
+
+	var succeeded, failed []*Server
+	var firstErr, notFoundErr error
+	notFound := 0
+	for i, err := range errs {
+		if err == nil {
+			succeeded = append(succeeded, servers[i])
+			continue
+		}
+
+		if errors.Is(err, ErrKeyNotFound) {
+			notFound++
+			if notFoundErr == nil {
+				notFoundErr = err
+			}
+			// the key is already gone from a server which does not hold it
+			if opCode == OPCODE_DEL {
+				succeeded = append(succeeded, servers[i])
+				continue
+			}
+		}
+		failed = append(failed, servers[i])
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// every server agreed the key does not exist, it is the result but not a failure
+	if notFound == len(servers) {
+		return notFoundErr
+	}
+
+	if len(succeeded) >= m.writeAck.required(len(servers)) {
+		if m.partialWritePolicy == PartialWriteInvalidate {
+			m.deleteFromServers(key, failed)
+		}
+		return nil
+	}
+
+	if m.partialWritePolicy == PartialWriteRollback {
+		m.deleteFromServers(key, succeeded)
+	} else if m.partialWritePolicy == PartialWriteInvalidate {
+		m.deleteFromServers(key, failed)
+	}
+
+	return firstErr
+}
+
+func (m *MemcachedClient) deleteFromServers(key string, servers []*Server) {
+	for _, s := range servers {
+		m.execServer(OPCODE_DEL, key, s.Addr, func(cmder *Commander) error {
+			return cmder.delete(key, 0)
+		})
+	}
+}
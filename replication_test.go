@@ -0,0 +1,129 @@
+package gomemcached
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shaoyuan1943/gomemcached/internal/fakeserver"
+)
+
+func fakeServerByAddr(servers []*fakeserver.Server, addr string) *fakeserver.Server {
+	for _, s := range servers {
+		if s.Addr() == addr {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestReplicationReadFallback(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetReplication(3, WriteAckAll, PartialWriteKeep)
+
+	_, err := m.Set(&KeyArgs{Key: "TestReplication", Value: "HelloWorld"})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	for _, s := range servers {
+		if _, _, ok := s.Value("TestReplication"); !ok {
+			t.Fatalf("key not replicated to %v", s.Addr())
+		}
+	}
+
+	primary := m.cluster.ChooseServersByKey("TestReplication", 1)[0]
+	fakeServerByAddr(servers, primary.Addr).Close()
+
+	var value string
+	_, err = m.Get("TestReplication", &value)
+	if err != nil || value != "HelloWorld" {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+
+	err = m.Delete(&KeyArgs{Key: "TestReplication"})
+	if err == nil {
+		t.Fatalf("Delete should fail with WriteAckAll when primary is down")
+	}
+
+	m.SetReplication(3, WriteAckQuorum, PartialWriteKeep)
+	_, err = m.Set(&KeyArgs{Key: "TestReplication", Value: "NiceTooMeetYou"})
+	if err != nil {
+		t.Fatalf("Set with quorum err: %v", err)
+	}
+
+	value = ""
+	_, err = m.Get("TestReplication", &value)
+	if err != nil || value != "NiceTooMeetYou" {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+}
+
+func TestReplicationConditionalWrite(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetReplication(2, WriteAckAll, PartialWriteKeep)
+
+	cas, err := m.Add(&KeyArgs{Key: "TestReplicationCAS", Value: "HelloWorld"})
+	if err != nil {
+		t.Fatalf("Add err: %v", err)
+	}
+
+	_, err = m.Add(&KeyArgs{Key: "TestReplicationCAS", Value: "Iamironman"})
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("Add existing key err: %v", err)
+	}
+
+	_, err = m.Set(&KeyArgs{Key: "TestReplicationCAS", Value: "Iamironman", CAS: cas + 100})
+	if !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("Set with wrong CAS err: %v", err)
+	}
+
+	for _, s := range m.cluster.ChooseServersByKey("TestReplicationCAS", 2) {
+		var value string
+		err := m.execServer(OPCODE_GET, "TestReplicationCAS", s.Addr, func(cmder *Commander) error {
+			_, _, err := cmder.get("TestReplicationCAS", &value)
+			return err
+		})
+		if err != nil || value != "HelloWorld" {
+			t.Fatalf("%v holds: %v, %v", s.Addr, value, err)
+		}
+	}
+}
+
+func TestReplicationDeleteMissing(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetReplication(3, WriteAckOne, PartialWriteKeep)
+
+	err := m.Delete(&KeyArgs{Key: "TestReplicationDelete"})
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Delete of missing key err: %v", err)
+	}
+
+	if _, err := m.Set(&KeyArgs{Key: "TestReplicationDelete", Value: "HelloWorld"}); err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	// a replica which does not hold the key does not fail Delete
+	replica := m.cluster.ChooseServersByKey("TestReplicationDelete", 3)[2]
+	m.deleteFromServers("TestReplicationDelete", []*Server{replica})
+	m.SetReplication(3, WriteAckAll, PartialWriteKeep)
+	if err := m.Delete(&KeyArgs{Key: "TestReplicationDelete"}); err != nil {
+		t.Fatalf("Delete err: %v", err)
+	}
+	for _, s := range servers {
+		if _, _, ok := s.Value("TestReplicationDelete"); ok {
+			t.Fatalf("key not deleted from %v", s.Addr())
+		}
+	}
+}
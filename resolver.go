@@ -0,0 +1,329 @@
+package gomemcached
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ResolveInterval = time.Duration(30) * time.Second
+	ResolveTimeout  = time.Duration(5) * time.Second
+)
+
+// ServerResolver returns the address list of memcached servers.
+// Cluster polls `Resolve` periodically, the servers which are not in cluster
+// will be added and the servers which are not in the list will be removed.
+type ServerResolver interface {
+	Resolve(ctx context.Context) ([]string, error)
+}
+
+// ServerWatcher is implemented by resolvers that push address lists instead of being polled.
+// The channel is closed when ctx is done.
+type ServerWatcher interface {
+	Watch(ctx context.Context) (<-chan []string, error)
+}
+
+// StaticResolver always returns the same address list.
+type StaticResolver []string
+
+func NewStaticResolver(addrs ...string) StaticResolver {
+	return StaticResolver(addrs)
+}
+
+func (r StaticResolver) Resolve(ctx context.Context) ([]string, error) {
+	addrs := make([]string, len(r))
+	copy(addrs, r)
+	return addrs, nil
+}
+
+// FileResolver reads address list from a JSON or YAML file, the format is decided by file extension.
+// JSON file is an array of addresses or an object with a "servers" array.
+// YAML file is a sequence of addresses, optionally under a "servers" key.
+type FileResolver struct {
+	Path string
+	// The interval of checking whether file has been modified.
+	PollInterval time.Duration
+}
+
+func NewFileResolver(path string) *FileResolver {
+	return &FileResolver{
+		Path:         path,
+		PollInterval: time.Duration(1) * time.Second,
+	}
+}
+
+func (r *FileResolver) Resolve(ctx context.Context) ([]string, error) {
+	data, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(r.Path)) {
+	case ".yaml", ".yml":
+		return parseYAMLServerList(data)
+	default:
+		return parseJSONServerList(data)
+	}
+}
+
+func (r *FileResolver) Watch(ctx context.Context) (<-chan []string, error) {
+	addrs, err := r.Resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	ch := make(chan []string, 1)
+	ch <- addrs
+	go func() {
+		defer close(ch)
+
+		modTime, size := info.ModTime(), info.Size()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(r.Path)
+			if err != nil || (info.ModTime().Equal(modTime) && info.Size() == size) {
+				continue
+			}
+
+			addrs, err := r.Resolve(ctx)
+			if err != nil {
+				// file is being written, try again at next tick
+				continue
+			}
+			modTime, size = info.ModTime(), info.Size()
+
+			select {
+			case ch <- addrs:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+func parseJSONServerList(data []byte) ([]string, error) {
+	var addrs []string
+	if err := json.Unmarshal(data, &addrs); err == nil {
+		return addrs, nil
+	}
+
+	var obj struct {
+		Servers []string `json:"servers"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+
+	return obj.Servers, nil
+}
+
+// parseYAMLServerList only understands the small subset of YAML used by server lists:
+//
+//	servers:
+//	  - 10.0.0.1:11211
+//	  - 10.0.0.2:11211
+//
+// or a top level sequence, or a flow sequence such as `servers: [a, b]`.
+func parseYAMLServerList(data []byte) ([]string, error) {
+	var addrs []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if len(line) <= 0 || line == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "-") {
+			addrs = append(addrs, unquoteYAML(strings.TrimSpace(line[1:])))
+			continue
+		}
+
+		if i := strings.Index(line, ":"); i >= 0 && strings.TrimSpace(line[:i]) == "servers" {
+			rest := strings.TrimSpace(line[i+1:])
+			if strings.HasPrefix(rest, "[") && strings.HasSuffix(rest, "]") {
+				for _, v := range strings.Split(rest[1:len(rest)-1], ",") {
+					if v = unquoteYAML(strings.TrimSpace(v)); len(v) > 0 {
+						addrs = append(addrs, v)
+					}
+				}
+			}
+			continue
+		}
+
+		return nil, ErrInvalidArguments
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return addrs, nil
+}
+
+func unquoteYAML(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// DNSResolver looks up servers in DNS.
+// If `Service` is empty, `Host` is resolved by A/AAAA lookup and every address is joined with `Port`,
+// otherwise SRV records of _Service._Proto.Host are used.
+type DNSResolver struct {
+	Host    string
+	Port    int
+	Service string
+	Proto   string
+	// Replaceable resolver, net.DefaultResolver is used when it is nil.
+	Resolver *net.Resolver
+}
+
+func NewDNSResolver(host string, port int) *DNSResolver {
+	return &DNSResolver{Host: host, Port: port}
+}
+
+func NewSRVResolver(service, proto, name string) *DNSResolver {
+	return &DNSResolver{Host: name, Service: service, Proto: proto}
+}
+
+func (r *DNSResolver) Resolve(ctx context.Context) ([]string, error) {
+	resolver := r.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	var addrs []string
+	if len(r.Service) > 0 {
+		_, records, err := resolver.LookupSRV(ctx, r.Service, r.Proto, r.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+		}
+	} else {
+		hosts, err := resolver.LookupHost(ctx, r.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, host := range hosts {
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(r.Port)))
+		}
+	}
+
+	// keep the order stable, DNS servers usually shuffle records
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+func (cl *Cluster) setServerResolver(resolver ServerResolver, interval time.Duration) {
+	cl.Lock()
+	if cl.closed {
+		cl.Unlock()
+		return
+	}
+	if cl.resolverQuitF != nil {
+		cl.resolverQuitF()
+	}
+	ctx, quitF := context.WithCancel(cl.ctx)
+	cl.resolverQuitF = quitF
+	cl.wg.Add(1)
+	cl.Unlock()
+
+	if interval <= 0 {
+		interval = ResolveInterval
+	}
+
+	if watcher, ok := resolver.(ServerWatcher); ok {
+		if ch, err := watcher.Watch(ctx); err == nil {
+			go cl.watchServerList(ctx, ch)
+			return
+		}
+	}
+
+	go cl.pollServerResolver(ctx, resolver, interval)
+}
+
+func (cl *Cluster) watchServerList(ctx context.Context, ch <-chan []string) {
+	defer cl.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case addrs, ok := <-ch:
+			if !ok {
+				return
+			}
+			cl.updateServerList(addrs)
+		}
+	}
+}
+
+func (cl *Cluster) pollServerResolver(ctx context.Context, resolver ServerResolver, interval time.Duration) {
+	defer cl.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		resolveCtx, cancel := context.WithTimeout(ctx, ResolveTimeout)
+		addrs, err := resolver.Resolve(resolveCtx)
+		cancel()
+		if err == nil {
+			cl.updateServerList(addrs)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cl *Cluster) updateServerList(addrs []string) {
+	// an empty list is most likely a broken resolver, keep the current servers
+	if len(addrs) <= 0 {
+		return
+	}
+
+	cl.applyServerList(addrs)
+}
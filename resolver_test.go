@@ -0,0 +1,154 @@
+package gomemcached
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/shaoyuan1943/gomemcached/internal/fakeserver"
+)
+
+func waitServerAddrs(t *testing.T, cl *Cluster, want []string) {
+	want = append([]string(nil), want...)
+	sort.Strings(want)
+
+	var addrs []string
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		addrs = cl.getServerAddrs()
+		sort.Strings(addrs)
+		if reflect.DeepEqual(addrs, want) {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Fatalf("servers: %v, want: %v", addrs, want)
+}
+
+func TestStaticResolver(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+	addrs := fakeServerAddrs(servers)
+
+	m := NewMemcachedClient(addrs[:1], 2).(*MemcachedClient)
+	defer m.Exit()
+
+	m.SetServerResolver(NewStaticResolver(addrs[1:]...), time.Millisecond*10)
+	waitServerAddrs(t, m.cluster, addrs[1:])
+
+	_, err := m.Set(&KeyArgs{Key: "TestStaticResolver", Value: "HelloWorld"})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	var value string
+	_, err = m.Get("TestStaticResolver", &value)
+	if err != nil || value != "HelloWorld" {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+}
+
+func TestFileResolver(t *testing.T) {
+	servers := startFakeServers(t, 3)
+	defer closeFakeServers(servers)
+	addrs := fakeServerAddrs(servers)
+
+	dir, err := ioutil.TempDir("", "gomemcached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "servers.yaml")
+	content := "servers:\n  - " + addrs[0] + "\n  - \"" + addrs[1] + "\"\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMemcachedClient(addrs[2:], 2).(*MemcachedClient)
+	defer m.Exit()
+
+	resolver := NewFileResolver(path)
+	resolver.PollInterval = time.Millisecond * 10
+	m.SetServerResolver(resolver, 0)
+	waitServerAddrs(t, m.cluster, addrs[:2])
+
+	// make sure modification time changes on coarse file systems
+	time.Sleep(time.Millisecond * 20)
+	content = "servers: [" + addrs[1] + ", " + addrs[2] + "] # rotated\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitServerAddrs(t, m.cluster, addrs[1:])
+}
+
+func TestResolverLateServer(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+	defer fastHeartbeat()()
+
+	// the second server is announced before it accepts connections
+	addrs := fakeServerAddrs(servers)
+	servers[1].Close()
+
+	m := NewMemcachedClient(addrs[:1], 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetFailurePolicy(FailureFailFast, 0)
+
+	m.SetServerResolver(NewStaticResolver(addrs...), time.Millisecond*10)
+	waitServerAddrs(t, m.cluster, addrs)
+
+	// the keys stay on the servers which accept connections
+	for i := 0; i < 100; i++ {
+		_, err := m.Set(&KeyArgs{Key: fmt.Sprintf("TestResolverLateServer%v", i), Value: "HelloWorld"})
+		if err != nil {
+			t.Fatalf("Set before server is up err: %v", err)
+		}
+	}
+
+	late, err := fakeserver.StartAt(addrs[1])
+	if err != nil {
+		t.Fatalf("restart fake server err: %v", err)
+	}
+	defer late.Close()
+
+	waitFor(t, "server on ring", func() bool {
+		for i := 0; i < 100; i++ {
+			_, err := m.Set(&KeyArgs{Key: fmt.Sprintf("TestResolverLateServer%v", i), Value: "HelloWorld"})
+			if err != nil {
+				t.Fatalf("Set after server is up err: %v", err)
+			}
+		}
+		return late.Len() > 0
+	})
+}
+
+func TestParseServerList(t *testing.T) {
+	want := []string{"10.0.0.1:11211", "10.0.0.2:11211"}
+
+	addrs, err := parseJSONServerList([]byte(`["10.0.0.1:11211", "10.0.0.2:11211"]`))
+	if err != nil || !reflect.DeepEqual(addrs, want) {
+		t.Errorf("json array: %v, %v", addrs, err)
+	}
+
+	addrs, err = parseJSONServerList([]byte(`{"servers": ["10.0.0.1:11211", "10.0.0.2:11211"]}`))
+	if err != nil || !reflect.DeepEqual(addrs, want) {
+		t.Errorf("json object: %v, %v", addrs, err)
+	}
+
+	addrs, err = parseYAMLServerList([]byte("---\n- 10.0.0.1:11211\n- '10.0.0.2:11211'\n"))
+	if err != nil || !reflect.DeepEqual(addrs, want) {
+		t.Errorf("yaml sequence: %v, %v", addrs, err)
+	}
+
+	_, err = parseYAMLServerList([]byte("servers:\n  foo: bar\n"))
+	if err == nil {
+		t.Errorf("yaml mapping should fail")
+	}
+}
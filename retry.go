@@ -0,0 +1,123 @@
+package gomemcached
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryClass is a set of error classes which can be retried.
+type RetryClass uint8
+
+const (
+	// Read or write timed out.
+	RetryTimeout RetryClass = 1 << iota
+	// Connection is broken or can not be established.
+	RetryNetwork
+	// Server answered busy or temporary failure.
+	RetryServerBusy
+	// No idle connection in the pool of server.
+	RetryNoConnection
+)
+
+// RetryPolicy retries failed operations on fresh connections.
+// Only the idempotent operations(Get, Touch, Set, Delete and Flush) are retried,
+// unless RetryNonIdempotent is true. Set and Delete with CAS are not idempotent.
+type RetryPolicy struct {
+	// Max attempts including the first one.
+	MaxAttempts int
+	// Base and max backoff between attempts, the actual backoff is randomly jittered.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	RetryOn    RetryClass
+	// Also retry Add, Replace, Append, Prepend, Increment, Decrement and the writes with CAS,
+	// which may be applied twice if the server received the first attempt.
+	RetryNonIdempotent bool
+}
+
+// isIdempotent reports whether the operation can be applied twice,
+// a write guarded by `cas` fails with ErrKeyExists when it is applied again.
+func isIdempotent(opCode uint8, cas uint64) bool {
+	if cas != 0 {
+		return false
+	}
+
+	switch opCode {
+	case OPCODE_GET, OPCODE_GETK, OPCODE_TOUCH, OPCODE_SET, OPCODE_DEL, OPCODE_NOOP, OPCODE_FLUSH:
+		return true
+	}
+	return false
+}
+
+func retryClassOf(err error) RetryClass {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrNoUsableConnection):
+		return RetryNoConnection
+	case errors.Is(err, ErrBusy), errors.Is(err, ErrTemporaryFailure):
+		return RetryServerBusy
+	case isStatusError(err):
+		return 0
+	}
+
+	// the errors of client such as ErrClientClosed and ErrInvalidArguments are never retried
+	switch errorClass(err) {
+	case ErrTimeout:
+		return RetryTimeout
+	case ErrNetwork:
+		return RetryNetwork
+	}
+
+	return 0
+}
+
+func (p *RetryPolicy) shouldRetry(opCode uint8, cas uint64, err error, attempt int) bool {
+	if p == nil || err == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+
+	if !isIdempotent(opCode, cas) && !p.RetryNonIdempotent {
+		return false
+	}
+
+	return p.RetryOn&retryClassOf(err) != 0
+}
+
+func (m *MemcachedClient) SetRetryPolicy(policy *RetryPolicy) {
+	m.retryPolicy = policy
+}
+
+func (m *MemcachedClient) retry(opCode uint8, cas uint64, attemptFunc func() error) error {
+	policy := m.retryPolicy
+	err := attemptFunc()
+	for attempt := 1; policy.shouldRetry(opCode, cas, err, attempt); attempt++ {
+		time.Sleep(jitteredBackoff(policy.Backoff, policy.MaxBackoff, attempt))
+		err = attemptFunc()
+	}
+
+	return err
+}
+
+// jitteredBackoff doubles base for every retry up to max,
+// and returns a random duration between the half and the whole of it.
+func jitteredBackoff(base, max time.Duration, retry int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	// doubling stops before it overflows
+	backoff := base
+	for i := 1; i < retry && backoff <= math.MaxInt64/2; i++ {
+		if max > 0 && backoff >= max {
+			break
+		}
+		backoff *= 2
+	}
+	if max > 0 && backoff > max {
+		backoff = max
+	}
+
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,109 @@
+package gomemcached
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, RetryOn: RetryTimeout | RetryNetwork}
+
+	if !policy.shouldRetry(OPCODE_GET, 0, timeoutError{}, 1) {
+		t.Errorf("timeout of Get is not retried")
+	}
+	if !policy.shouldRetry(OPCODE_SET, 0, io.ErrUnexpectedEOF, 2) {
+		t.Errorf("network error of Set is not retried")
+	}
+	if policy.shouldRetry(OPCODE_GET, 0, timeoutError{}, 3) {
+		t.Errorf("retried beyond MaxAttempts")
+	}
+	if policy.shouldRetry(OPCODE_GET, 0, ErrKeyNotFound, 1) {
+		t.Errorf("memcached status is retried")
+	}
+	if policy.shouldRetry(OPCODE_GET, 0, ErrBusy, 1) {
+		t.Errorf("busy server is retried without RetryServerBusy")
+	}
+	if policy.shouldRetry(OPCODE_INCR, 0, timeoutError{}, 1) {
+		t.Errorf("Increment is retried")
+	}
+	if policy.shouldRetry(OPCODE_SET, 12345, timeoutError{}, 1) {
+		t.Errorf("Set with CAS is retried")
+	}
+	for _, err := range []error{ErrClientClosed, ErrInvalidArguments, ErrNotFoundServerNode, errors.New("unknown")} {
+		if policy.shouldRetry(OPCODE_GET, 0, err, 1) {
+			t.Errorf("%v is retried", err)
+		}
+	}
+
+	policy.RetryNonIdempotent = true
+	if !policy.shouldRetry(OPCODE_APPEND, 0, timeoutError{}, 1) {
+		t.Errorf("Append is not retried with RetryNonIdempotent")
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	if d := jitteredBackoff(time.Second, 0, 100); d < time.Second {
+		t.Errorf("backoff of many retries without max: %v", d)
+	}
+	if d := jitteredBackoff(time.Millisecond, time.Second, 100); d < time.Second/2 || d > time.Second {
+		t.Errorf("backoff beyond max: %v", d)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	readTimeout := ReadTimeout
+	ReadTimeout = time.Millisecond * 100
+	defer func() { ReadTimeout = readTimeout }()
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 5).(*MemcachedClient)
+	defer m.Exit()
+	m.SetRetryPolicy(&RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond * 50,
+		RetryOn:     RetryTimeout | RetryNetwork,
+	})
+
+	_, err := m.Set(&KeyArgs{Key: "TestRetryPolicy", Value: "HelloWorld"})
+	if err != nil {
+		t.Fatalf("Set err: %v", err)
+	}
+
+	// the first attempt times out, the retry is answered in time
+	slowdown := func() {
+		servers[0].SetDelay(time.Millisecond * 300)
+		go func() {
+			time.Sleep(time.Millisecond * 50)
+			servers[0].SetDelay(0)
+		}()
+	}
+
+	slowdown()
+	var value string
+	_, err = m.Get("TestRetryPolicy", &value)
+	if err != nil || value != "HelloWorld" {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+	if n := servers[0].OpCount(OPCODE_GET); n != 2 {
+		t.Errorf("server received %v gets", n)
+	}
+
+	slowdown()
+	_, _, err = m.Increment(&KeyArgs{Key: "TestRetryPolicyCounter", Delta: 1})
+	if err == nil {
+		t.Fatalf("Increment should time out")
+	}
+	if n := servers[0].OpCount(OPCODE_INCR); n != 1 {
+		t.Errorf("Increment is sent %v times", n)
+	}
+}
@@ -0,0 +1,233 @@
+package gomemcached
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+// GetTo writes the raw value of key to w while it is read from connection,
+// the value is never buffered as a whole. Return value is CAS.
+// The value is read from primary server on a connection of pool, near cache and replicas are not used.
+func (m *MemcachedClient) GetTo(key string, w io.Writer) (uint64, error) {
+	key, err := m.checkKey(key)
+	if err != nil {
+		return 0, err
+	}
+
+	var modifyCAS uint64
+	var resErr error
+
+	err = m.execStream(OPCODE_GET, key, func(cmder *Commander) error {
+		modifyCAS, resErr = cmder.getTo(key, w)
+		return resErr
+	})
+
+	return modifyCAS, err
+}
+
+// SetFrom stores `size` bytes of r as the raw value of key while they are written to connection,
+// `args.Value` is ignored. Return value is CAS.
+// The value is written to primary server only.
+func (m *MemcachedClient) SetFrom(args *KeyArgs, r io.Reader, size int64) (uint64, error) {
+	if size < 0 || size > math.MaxUint32-0x08-MaxKeyLength {
+		return 0, fmt.Errorf("%w: value size %v", ErrInvalidArguments, size)
+	}
+
+	args, err := m.checkArgs(args)
+	if err != nil {
+		return 0, err
+	}
+	defer m.invalidateNearCache(args.Key)
+
+	var modifyCAS uint64
+	var resErr error
+
+	err = m.execStream(OPCODE_SET, args.Key, func(cmder *Commander) error {
+		modifyCAS, resErr = cmder.storeFrom(args, r, size)
+		return resErr
+	})
+
+	return modifyCAS, err
+}
+
+// execStream executes the streaming operation of key on a pooled connection of primary server,
+// pipelined connections can not be used because their responses are read by their own goroutines.
+// It is never retried because the reader or writer of caller has been consumed.
+func (m *MemcachedClient) execStream(opCode uint8, key string, cmdFunc func(cmder *Commander) error) error {
+	if err := m.enter(); err != nil {
+		return err
+	}
+	defer m.leave()
+
+	servers := m.cluster.ChooseServersByKey(key, 1)
+	if len(servers) <= 0 {
+		return newOpError(opCode, key, "", ErrNotFoundServerNode)
+	}
+
+	server, cmder, err := m.cluster.ChooseServerPooledCommander(servers[0].Addr)
+	if err != nil {
+		return newOpError(opCode, key, servers[0].Addr, err)
+	}
+
+	return newOpError(opCode, key, server.Addr, m.run(server, cmder, cmdFunc))
+}
+
+// streamReader reads the connection of commander and extends the read deadline for every read,
+// so a large value is not limited by a single ReadTimeout.
+type streamReader struct {
+	cmder *Commander
+}
+
+func (r streamReader) Read(p []byte) (int, error) {
+	r.cmder.conn.SetReadDeadline(time.Now().Add(ReadTimeout))
+	return r.cmder.rw.Read(p)
+}
+
+// streamWriter is the counterpart of streamReader for writing.
+type streamWriter struct {
+	cmder *Commander
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	w.cmder.conn.SetWriteDeadline(time.Now().Add(WriterTimeout))
+	return w.cmder.rw.Write(p)
+}
+
+// callerError is an error caused by caller, such as its reader or writer or the cancellation of a hedged request.
+// The connection and server are not bad by it, though the connection is left in the middle of a request or response.
+type callerError struct {
+	err error
+}
+
+func (e *callerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *callerError) Unwrap() error {
+	return e.err
+}
+
+// callerWriter marks the errors of the writer of caller.
+type callerWriter struct {
+	w io.Writer
+}
+
+func (w callerWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		return n, &callerError{err}
+	}
+	return n, nil
+}
+
+// callerReader marks the errors of the reader of caller, io.EOF is kept for io.CopyN.
+type callerReader struct {
+	r io.Reader
+}
+
+func (r callerReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		return n, &callerError{err}
+	}
+	return n, err
+}
+
+// getTo copies the value of key from connection to w.
+// The connection is left in the middle of response when an error is not a status,
+// so it must be given up.
+func (cmder *Commander) getTo(key string, w io.Writer) (uint64, error) {
+	req := bytebufferpool.Get()
+	defer bytebufferpool.Put(req)
+
+	writeGetReq(req, OPCODE_GET, key, 0x00)
+	if err := cmder.write(req); err != nil {
+		return 0, err
+	}
+
+	if err := cmder.flush2Server(); err != nil {
+		return 0, err
+	}
+
+	rsp, keyLen, bodyLen, err := cmder.readHeader()
+	if err != nil {
+		return 0, err
+	}
+
+	// extras and key are read into the buffer, so is the error message
+	prefixLen := uint32(rsp.extLen) + uint32(keyLen)
+	if rsp.status != STATUS_OK {
+		prefixLen = bodyLen
+	}
+	if prefixLen > bodyLen {
+		return 0, fmt.Errorf("%w: body of %v bytes is shorter than extras and key", ErrProtocol, bodyLen)
+	}
+
+	rsp.body = bytebufferpool.Get()
+	defer bytebufferpool.Put(rsp.body)
+	if prefixLen > 0 {
+		if _, err := cmder.readN(rsp.body, int(prefixLen)); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := rsp.statusErr(); err != nil {
+		return 0, err
+	}
+
+	if _, err := io.CopyN(callerWriter{w}, streamReader{cmder}, int64(bodyLen-prefixLen)); err != nil {
+		return 0, err
+	}
+
+	return rsp.cas, nil
+}
+
+// storeFrom sets the raw value of args.Key to `size` bytes copied from r to connection.
+func (cmder *Commander) storeFrom(args *KeyArgs, r io.Reader, size int64) (uint64, error) {
+	req := bytebufferpool.Get()
+	defer bytebufferpool.Put(req)
+
+	writeReqHeader(req, MAGIC_REQUEST, OPCODE_SET, (uint16)(len(args.Key)), 0x08, RAW_DATA, 0x00,
+		uint32(0x08+len(args.Key))+uint32(size), 0x00, args.CAS)
+
+	// extra:8byte |----flag:4----|----expiration:4----|
+	WriteUint32(req, 0)
+	WriteUint32(req, args.Expiration)
+	// key
+	req.WriteString(args.Key)
+
+	if err := cmder.write(req); err != nil {
+		return 0, err
+	}
+
+	n, err := io.CopyN(streamWriter{cmder}, callerReader{r}, size)
+	if err == io.EOF {
+		return 0, &callerError{fmt.Errorf("%w: reader ends after %v of %v bytes", ErrInvalidArguments, n, size)}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := cmder.flush2Server(); err != nil {
+		return 0, err
+	}
+
+	rsp, err := cmder.readResponse()
+	if err != nil {
+		return 0, err
+	}
+	defer bytebufferpool.Put(rsp.body)
+
+	if err := rsp.statusErr(); err != nil {
+		return 0, err
+	}
+
+	return rsp.cas, nil
+}
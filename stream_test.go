@@ -0,0 +1,85 @@
+package gomemcached
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	m.SetPipelining(1)
+	defer m.Exit()
+
+	value := bytes.Repeat([]byte("0123456789"), 200*1024)
+	cas, err := m.SetFrom(&KeyArgs{Key: "TestStream"}, bytes.NewReader(value), int64(len(value)))
+	if err != nil {
+		t.Fatalf("SetFrom err: %v", err)
+	}
+
+	var buf bytes.Buffer
+	getCAS, err := m.GetTo("TestStream", &buf)
+	if err != nil {
+		t.Fatalf("GetTo err: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), value) || getCAS != cas {
+		t.Errorf("GetTo: %v bytes, CAS %v, want %v bytes, CAS %v", buf.Len(), getCAS, len(value), cas)
+	}
+
+	// the streamed value is the same as the raw value
+	var raw []byte
+	if _, err := m.Get("TestStream", &raw); err != nil || !bytes.Equal(raw, value) {
+		t.Errorf("Get: %v bytes, %v", len(raw), err)
+	}
+
+	if _, err := m.GetTo("TestStreamMissing", &buf); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("GetTo of missing key: %v", err)
+	}
+
+	// short reader fails and the connection is not reused
+	_, err = m.SetFrom(&KeyArgs{Key: "TestStream"}, strings.NewReader("short"), 10)
+	if !errors.Is(err, ErrInvalidArguments) {
+		t.Errorf("SetFrom of short reader: %v", err)
+	}
+	buf.Reset()
+	if _, err := m.GetTo("TestStream", &buf); err != nil || buf.Len() != len(value) {
+		t.Errorf("GetTo after failed SetFrom: %v bytes, %v", buf.Len(), err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("client gone")
+}
+
+func TestStreamWriterError(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+	m.SetFailurePolicy(FailureFailFast, 0)
+	m.SetCircuitBreaker(&BreakerConfig{MinRequests: 2, ErrorRate: 0.5})
+
+	value := bytes.Repeat([]byte("0123456789"), 1024)
+	if _, err := m.SetFrom(&KeyArgs{Key: "TestStream"}, bytes.NewReader(value), int64(len(value))); err != nil {
+		t.Fatalf("SetFrom err: %v", err)
+	}
+
+	// errors of the writer of caller neither mark the server bad nor trip the breaker
+	for i := 0; i < 3; i++ {
+		if _, err := m.GetTo("TestStream", failingWriter{}); err == nil {
+			t.Fatalf("GetTo to failing writer should fail")
+		}
+	}
+
+	var raw []byte
+	if _, err := m.Get("TestStream", &raw); err != nil || !bytes.Equal(raw, value) {
+		t.Errorf("Get after writer errors: %v bytes, %v", len(raw), err)
+	}
+}
@@ -0,0 +1,139 @@
+package gomemcached
+
+import (
+	"time"
+)
+
+// The version counter of a tag is stored in key `TagKeyPrefix + tag`.
+var TagKeyPrefix = "tag:"
+
+// taggedValue is the envelope of a value stored by SetWithTags, it is stored with USE_TAGGED_FLAG.
+// `Value` is the msgpack encoding of the value, `Tags` are the versions of its tags when it was stored.
+type taggedValue struct {
+	Tags  map[string]uint64
+	Value []byte
+}
+
+// SetWithTags stores the value of key with the current versions of `tags`,
+// Get treats the value as missing once any of the tags is invalidated by InvalidateTags.
+func (m *MemcachedClient) SetWithTags(args *KeyArgs, tags ...string) (uint64, error) {
+	// versions are read before the value is written,
+	// so an invalidation meanwhile makes the value stale instead of being lost
+	versions, err := m.tagVersions(tags, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	encoder := getEncoder()
+	data, err := encoder.Encode(args.Value)
+	if err != nil {
+		putEncoder(encoder)
+		return 0, ErrMarshalFailed
+	}
+	value := &taggedValue{Tags: versions, Value: append([]byte(nil), data...)}
+	putEncoder(encoder)
+
+	taggedArgs := *args
+	taggedArgs.Value = value
+	taggedArgs.flag = USE_TAGGED_FLAG
+	return m.store(OPCODE_SET, &taggedArgs, true, nil)
+}
+
+// InvalidateTags moves the versions of `tags` on, so the values stored with them are missing for Get.
+func (m *MemcachedClient) InvalidateTags(tags ...string) error {
+	_, err := m.tagVersions(tags, 1)
+	return err
+}
+
+// tagVersions increases the version counters of tags by delta in a batch, and returns their versions.
+func (m *MemcachedClient) tagVersions(tags []string, delta uint64) (map[string]uint64, error) {
+	versions := make(map[string]uint64, len(tags))
+	if len(tags) <= 0 {
+		return versions, nil
+	}
+
+	// a counter which was evicted restarts from current time,
+	// so it does not go back to the versions stored before
+	initial := uint64(time.Now().UnixNano())
+	b := m.NewBatch()
+	for _, tag := range tags {
+		b.Increment(&KeyArgs{Key: TagKeyPrefix + tag, Delta: delta, Initial: initial})
+	}
+
+	for i, res := range b.Execute() {
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		versions[tags[i]] = res.Counter
+	}
+
+	return versions, nil
+}
+
+// checkTags returns ErrKeyNotFound when any of the tag versions is not current.
+func (m *MemcachedClient) checkTags(tags map[string]uint64) error {
+	if len(tags) <= 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+
+	versions, err := m.tagVersions(names, 0)
+	if err != nil {
+		return err
+	}
+
+	if staleTags(tags, versions) {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func staleTags(tags map[string]uint64, versions map[string]uint64) bool {
+	for tag, version := range tags {
+		if versions[tag] != version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeValueTags decodes data into value, and returns the tag versions of a tagged value.
+func decodeValueTags(flag uint32, data []byte, value interface{}) (map[string]uint64, error) {
+	if flag == USE_TAGGED_FLAG {
+		return decodeTaggedValue(data, value)
+	}
+
+	return nil, decodeValue(flag, data, value)
+}
+
+func decodeTaggedValue(data []byte, value interface{}) (map[string]uint64, error) {
+	var tagged taggedValue
+	if err := decodeValue(USE_MSGP_FLAG, data, &tagged); err != nil {
+		return nil, err
+	}
+
+	if err := decodeValue(USE_MSGP_FLAG, tagged.Value, value); err != nil {
+		return nil, err
+	}
+
+	return tagged.Tags, nil
+}
+
+// checkRawTags checks the tags of a raw value, the values which are not tagged always pass.
+func (m *MemcachedClient) checkRawTags(flag uint32, data []byte) error {
+	if flag != USE_TAGGED_FLAG {
+		return nil
+	}
+
+	var tagged taggedValue
+	if err := decodeValue(USE_MSGP_FLAG, data, &tagged); err != nil {
+		return err
+	}
+
+	return m.checkTags(tagged.Tags)
+}
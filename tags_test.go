@@ -0,0 +1,85 @@
+package gomemcached
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	servers := startFakeServers(t, 2)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	if _, err := m.SetWithTags(&KeyArgs{Key: "TestTagsProfile", Value: "profile"}, "user:1"); err != nil {
+		t.Fatalf("SetWithTags err: %v", err)
+	}
+	if _, err := m.SetWithTags(&KeyArgs{Key: "TestTagsFeed", Value: "feed"}, "user:1", "feed"); err != nil {
+		t.Fatalf("SetWithTags err: %v", err)
+	}
+	if _, err := m.SetWithTags(&KeyArgs{Key: "TestTagsOther", Value: "other"}, "user:2"); err != nil {
+		t.Fatalf("SetWithTags err: %v", err)
+	}
+
+	var value string
+	if _, err := m.Get("TestTagsFeed", &value); err != nil || value != "feed" {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+
+	if err := m.InvalidateTags("user:1"); err != nil {
+		t.Fatalf("InvalidateTags err: %v", err)
+	}
+
+	for _, key := range []string{"TestTagsProfile", "TestTagsFeed"} {
+		if _, err := m.Get(key, &value); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Get %v after invalidation: %v", key, err)
+		}
+		if _, err := m.GetAsync(key, &value).Wait(context.Background()); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("GetAsync %v after invalidation: %v", key, err)
+		}
+	}
+
+	var other, feed string
+	results := m.NewBatch().Get("TestTagsOther", &other).Get("TestTagsFeed", &feed).Execute()
+	if results[0].Err != nil || other != "other" {
+		t.Errorf("batch Get: %v, %v", other, results[0].Err)
+	}
+	if !errors.Is(results[1].Err, ErrKeyNotFound) {
+		t.Errorf("batch Get after invalidation: %v", results[1].Err)
+	}
+
+	// storing again picks the new versions
+	if _, err := m.SetWithTags(&KeyArgs{Key: "TestTagsFeed", Value: "feed2"}, "user:1", "feed"); err != nil {
+		t.Fatalf("SetWithTags err: %v", err)
+	}
+	value = ""
+	if _, err := m.Get("TestTagsFeed", &value); err != nil || value != "feed2" {
+		t.Errorf("Get: %v, %v", value, err)
+	}
+}
+
+func TestTagsForeignFlag(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 2).(*MemcachedClient)
+	defer m.Exit()
+
+	// small flags of other clients are not taken as tagged values
+	if _, err := m.SetItem(&Item{Key: "TestTagsForeign", Value: []byte("42"), Flags: 0x02}); err != nil {
+		t.Fatalf("SetItem err: %v", err)
+	}
+
+	var value []byte
+	if _, err := m.Get("TestTagsForeign", &value); err != nil || string(value) != "42" {
+		t.Errorf("Get: %s, %v", value, err)
+	}
+
+	value = nil
+	res := m.NewBatch().Get("TestTagsForeign", &value).Execute()
+	if res[0].Err != nil || string(value) != "42" {
+		t.Errorf("Batch Get: %s, %v", value, res[0].Err)
+	}
+}
@@ -0,0 +1,100 @@
+package gomemcached
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+var (
+	// Max attempts of Update before it gives up with ErrUpdateConflict.
+	UpdateMaxAttempts = 10
+	// Base and max backoff between attempts, the actual backoff is randomly jittered.
+	UpdateBackoff    = time.Duration(5) * time.Millisecond
+	UpdateMaxBackoff = time.Duration(200) * time.Millisecond
+)
+
+// UpdateFunc receives the current value(nil when the key does not exist),
+// returns the next value and its expiration.
+// Return ErrUpdateAbort to stop without writing, ErrUpdateDelete to delete the key.
+type UpdateFunc func(current interface{}) (interface{}, uint32, error)
+
+func (m *MemcachedClient) Update(key string, dst interface{}, fn UpdateFunc) (int, error) {
+	if dst == nil || reflect.ValueOf(dst).Kind() != reflect.Ptr {
+		return 0, ErrInvalidArguments
+	}
+
+	for attempt := 1; attempt <= UpdateMaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(jitteredBackoff(UpdateBackoff, UpdateMaxBackoff, attempt-1))
+		}
+
+		// decoding appends to slices, start from an empty value every time
+		elem := reflect.ValueOf(dst).Elem()
+		elem.Set(reflect.Zero(elem.Type()))
+
+		var current interface{}
+		cas, err := m.Get(key, dst)
+		if err == nil {
+			current = dst
+		} else if !errors.Is(err, ErrKeyNotFound) {
+			return attempt, err
+		}
+
+		next, expiration, err := fn(current)
+		if errors.Is(err, ErrUpdateAbort) {
+			return attempt, nil
+		}
+
+		if errors.Is(err, ErrUpdateDelete) {
+			if current == nil {
+				return attempt, nil
+			}
+
+			err = m.Delete(&KeyArgs{Key: key, CAS: cas})
+			if err == nil || errors.Is(err, ErrKeyNotFound) {
+				return attempt, nil
+			}
+
+			if errors.Is(err, ErrKeyExists) {
+				continue
+			}
+			return attempt, err
+		}
+
+		if err != nil {
+			return attempt, err
+		}
+
+		// the key does not exist, Add fails if another one stored it meanwhile
+		args := &KeyArgs{Key: key, Value: next, Expiration: expiration, CAS: cas}
+		if current == nil {
+			_, err = m.Add(args)
+		} else {
+			_, err = m.Set(args)
+		}
+
+		if err == nil {
+			return attempt, assignValue(next, dst)
+		}
+
+		if !errors.Is(err, ErrKeyExists) && !errors.Is(err, ErrKeyNotFound) && !errors.Is(err, ErrItemNotStored) {
+			return attempt, err
+		}
+	}
+
+	return UpdateMaxAttempts, ErrUpdateConflict
+}
+
+// assignValue assigns value to dst through msgpack, the same as storing and getting it.
+func assignValue(value interface{}, dst interface{}) error {
+	encoder := getEncoder()
+	defer putEncoder(encoder)
+
+	data, err := encoder.Encode(value)
+	if err != nil {
+		return ErrMarshalFailed
+	}
+
+	return decodeValue(USE_MSGP_FLAG, data, dst)
+}
@@ -0,0 +1,67 @@
+package gomemcached
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	servers := startFakeServers(t, 1)
+	defer closeFakeServers(servers)
+
+	m := NewMemcachedClient(fakeServerAddrs(servers), 10).(*MemcachedClient)
+	defer m.Exit()
+
+	maxAttempts, maxBackoff := UpdateMaxAttempts, UpdateMaxBackoff
+	UpdateMaxAttempts, UpdateMaxBackoff = 1000, UpdateBackoff*4
+	defer func() { UpdateMaxAttempts, UpdateMaxBackoff = maxAttempts, maxBackoff }()
+
+	increase := func(current interface{}) (interface{}, uint32, error) {
+		if current == nil {
+			return 1, 0, nil
+		}
+		return *current.(*int) + 1, 0, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				var value int
+				if _, err := m.Update("TestUpdate", &value, increase); err != nil {
+					t.Errorf("Update err: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var value int
+	_, err := m.Get("TestUpdate", &value)
+	if err != nil || value != 50 {
+		t.Fatalf("Get: %v, %v", value, err)
+	}
+
+	attempts, err := m.Update("TestUpdate", &value, func(current interface{}) (interface{}, uint32, error) {
+		return nil, 0, ErrUpdateAbort
+	})
+	if attempts != 1 || err != nil || value != 50 {
+		t.Fatalf("abort: %v, %v, %v", attempts, value, err)
+	}
+
+	_, err = m.Update("TestUpdate", &value, func(current interface{}) (interface{}, uint32, error) {
+		return nil, 0, ErrUpdateDelete
+	})
+	if err != nil {
+		t.Fatalf("delete err: %v", err)
+	}
+
+	_, err = m.Get("TestUpdate", &value)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Get after delete err: %v", err)
+	}
+}